@@ -0,0 +1,102 @@
+package blooms
+
+import "sync/atomic"
+
+// AtomicFilter is bloomfilter which sets bits with atomic operations.
+// Has is wait-free and Add is lock-free, so that many goroutines
+// can insert into it without serializing on a mutex.
+type AtomicFilter struct {
+	// Number of elements updated atomically.
+	// It is first to be 64bit aligned on 32bit platforms.
+	n int64
+	*baseFilter
+}
+
+// NewAtomicFilter creates a new lock-free bloomfilter instance
+func NewAtomicFilter(filterSize, hasherNumber int, opts ...Option) *AtomicFilter {
+	return &AtomicFilter{
+		baseFilter: New(filterSize, hasherNumber, opts...).baseFilter,
+	}
+}
+
+// Add adds a new element into filter
+func (a *AtomicFilter) Add(element []byte) {
+	a.AddHash(a.createHash(element))
+}
+
+// AddHash adds a new element into filter by its digest.
+// Digest must be computed with the same hasher as filter.
+func (a *AtomicFilter) AddHash(d Digest) {
+	bits := a.bits.(bitSet)
+	for i := 0; i < a.k; i++ {
+		bits.setAtomic(a.location(d, i))
+	}
+	atomic.AddInt64(&a.n, 1)
+}
+
+// Has checks if a element already exists in filter
+func (a *AtomicFilter) Has(element []byte) bool {
+	return a.HasHash(a.createHash(element))
+}
+
+// HasHash checks if a element already exists in filter by its digest.
+// Digest must be computed with the same hasher as filter.
+func (a *AtomicFilter) HasHash(d Digest) bool {
+	bits := a.bits.(bitSet)
+	for i := 0; i < a.k; i++ {
+		if !bits.testAtomic(a.location(d, i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Count gets the number of added elements
+func (a *AtomicFilter) Count() int64 {
+	return atomic.LoadInt64(&a.n)
+}
+
+// GetFalsePositiveIncidence gets the incidence of false positive
+func (a *AtomicFilter) GetFalsePositiveIncidence() float64 {
+	return getFalsePositiveIncidence(a.k, int(a.Count()), a.m)
+}
+
+// GobEncode encodes a snapshot of filter to gobs stream
+func (a *AtomicFilter) GobEncode() ([]byte, error) {
+	data := a.toGobs()
+	data.Words = a.bits.(bitSet).snapshot()
+	data.N = int(a.Count())
+	return gobEncode(data)
+}
+
+// GobDecode decodes gob stream
+func (a *AtomicFilter) GobDecode(data []byte) error {
+	var bg baseGobs
+	err := gobDecode(data, &bg)
+	if err != nil {
+		return err
+	}
+
+	base, err := bg.toFilter()
+	if err != nil {
+		return err
+	}
+	return a.load(base)
+}
+
+// load replaces filter with decoded base filter,
+// which takes the key of filter if it is keyed
+func (a *AtomicFilter) load(base *baseFilter) error {
+	var receiver Hasher
+	if a.baseFilter != nil {
+		receiver = a.hasher
+	}
+	hasher, err := withKeyOf(base.hasher, receiver)
+	if err != nil {
+		return err
+	}
+	base.hasher = hasher
+	a.baseFilter = base
+	a.n = int64(base.n)
+	return nil
+}
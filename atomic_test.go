@@ -0,0 +1,188 @@
+package blooms
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewAtomicFilter(t *testing.T) {
+	Convey("Given filter size, hasher number", t, func() {
+		m := 128
+		k := 5
+
+		Convey("When creating a new atomic bloom filter", func() {
+			a := NewAtomicFilter(m, k)
+
+			Convey("Then created instance should be expected", func() {
+				So(a, ShouldNotBeNil)
+				So(a.m, ShouldEqual, m)
+				So(len(a.bits.(bitSet)), ShouldEqual, 2)
+				So(a.k, ShouldEqual, k)
+				So(a.s, ShouldEqual, 0)
+
+			})
+		})
+	})
+}
+
+func TestAtomicFilter_Has(t *testing.T) {
+	Convey("Given atomic bloom filter and set element", t, func() {
+		a := NewAtomicFilter(128, 5)
+		b := New(128, 5)
+		e := []byte("test")
+		a.Add(e)
+		b.Add(e)
+
+		Convey("When check set element", func() {
+			check := a.Has(e)
+
+			Convey("Then true should be returned with the same bits as bloom filter", func() {
+				So(check, ShouldBeTrue)
+				So(a.Count(), ShouldEqual, 1)
+				So(a.bits, ShouldResemble, b.bits)
+
+			})
+		})
+
+		Convey("When check not set element", func() {
+			check := a.Has([]byte("not_set"))
+
+			Convey("Then false should be returned", func() {
+				So(check, ShouldBeFalse)
+
+			})
+		})
+	})
+}
+
+func TestAtomicFilter_Concurrent(t *testing.T) {
+	Convey("Given atomic bloom filter", t, func() {
+		a := NewAtomicFilter(1<<16, 5)
+		workers := 16
+		perWorker := 1000
+
+		Convey("When adding and checking concurrently", func() {
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(2)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						a.Add([]byte{byte(w), byte(i), byte(i >> 8)})
+					}
+				}(w)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						a.Has([]byte{byte(w), byte(i), byte(i >> 8)})
+					}
+					a.GobEncode()
+					a.WriteTo(ioutil.Discard)
+				}(w)
+			}
+			wg.Wait()
+
+			Convey("Then no element should be lost", func() {
+				So(a.Count(), ShouldEqual, workers*perWorker)
+				var missing int
+				for w := 0; w < workers; w++ {
+					for i := 0; i < perWorker; i++ {
+						if !a.Has([]byte{byte(w), byte(i), byte(i >> 8)}) {
+							missing++
+						}
+					}
+				}
+				So(missing, ShouldEqual, 0)
+
+			})
+		})
+	})
+}
+
+func TestAtomicFilter_GobDecode(t *testing.T) {
+	Convey("Given atomic bloom filter converted to gobs stream", t, func() {
+		a := NewAtomicFilter(128, 2)
+		a.Add([]byte("test"))
+
+		buf, _ := a.GobEncode()
+
+		Convey("When decoding gobs stream", func() {
+			res := &AtomicFilter{}
+			err := res.GobDecode(buf)
+
+			Convey("Then filter should be restored", func() {
+				So(err, ShouldBeNil)
+				So(res.m, ShouldEqual, 128)
+				So(res.k, ShouldEqual, a.k)
+				So(res.Count(), ShouldEqual, 1)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding gobs stream as bloom filter", func() {
+			res := &BloomFilter{}
+			err := res.GobDecode(buf)
+
+			Convey("Then filter should be restored", func() {
+				So(err, ShouldBeNil)
+				So(res.Count(), ShouldEqual, 1)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func benchmarkParallelAdd(b *testing.B, f Filter, goroutines int) {
+	b.ReportAllocs()
+	var wg sync.WaitGroup
+	per := b.N/goroutines + 1
+	b.ResetTimer()
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			e := make([]byte, 8)
+			for i := 0; i < per; i++ {
+				e[0], e[1], e[2], e[3] = byte(g), byte(i), byte(i>>8), byte(i>>16)
+				f.Add(e)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkAtomicFilter_Add(b *testing.B) {
+	for _, goroutines := range []int{1, 2, 4, 8, 16, 32, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			benchmarkParallelAdd(b, NewAtomicFilter(1<<20, 7), goroutines)
+		})
+	}
+}
+
+func BenchmarkBloomFilter_Add(b *testing.B) {
+	for _, goroutines := range []int{1, 2, 4, 8, 16, 32, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			benchmarkParallelAdd(b, New(1<<20, 7), goroutines)
+		})
+	}
+}
+
+func BenchmarkAtomicFilter_Has(b *testing.B) {
+	a := NewAtomicFilter(1<<20, 7)
+	for i := 0; i < 1<<16; i++ {
+		a.Add([]byte{byte(i), byte(i >> 8)})
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		e := []byte("test")
+		for pb.Next() {
+			a.Has(e)
+		}
+	})
+}
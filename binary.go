@@ -0,0 +1,936 @@
+package blooms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync/atomic"
+)
+
+// Binary format of filters, version 1 and 2.
+// All integers are little endian and strings are a uint8 length followed by bytes.
+//
+//	header:
+//	  magic    [4]byte  "BLMF"
+//	  version  uint8    1, or 2 if compressed
+//	  type     uint8    1 bloom, 2 counting, 3 partitioned, 4 scalable, 5 sharded, 6 atomic
+//	body of the type
+//	trailer:
+//	  crc32c   uint32   CRC32 Castagnoli of header and body
+//
+// Bloom, counting and atomic filters have a base as body.
+//
+//	base:
+//	  m        uint64   number of slots
+//	  k        uint32   number of hash functions
+//	  s        uint64   partition size, 0 unless partitioned
+//	  n        int64    number of added elements
+//	  seed     uint64   seed of digest, 0 unless a filter of scalable filter
+//	  hasher   string   ID of hash function
+//	  keyCheck uint64   tag of key for keyed hash function, 0 unless keyed
+//	  strategy string   ID of index strategy
+//	  payload           ceil(m/64) uint64 words of bit map, or m uint8 counters
+//
+// Bit i of bit map is bit i%64 of word i/64.
+// Readers allocate payload of the size in base at once, which is checked against data
+// of known length such as of UnmarshalBinary, but streams of unknown length are trusted.
+// Counters are payload of counting filter and sharded counting filter.
+//
+// Version 2 is version 1 whose bit maps are preceded by their encoding,
+// and it is written when filter is compressed. Counters are never compressed.
+//
+//	bit map:
+//	  encoding uint8    0 raw, or 1 Golomb-Rice coded
+//	  raw:
+//	    ceil(m/64) uint64 words
+//	  Golomb-Rice coded:
+//	    count    uint64   number of set bits
+//	    rice     uint8    parameter r of Rice code, less than 62
+//	    length   uint64   number of bytes of codes
+//	    codes             a code per set bit in ascending order
+//
+// Code of set bit i is gap g = i-j-1 from the previous set bit j, or i for the first one.
+// It is g>>r in unary as g>>r one bits and a zero bit, followed by the low r bits of g
+// from the least significant one. Codes are packed from the least significant bit of each byte,
+// and the last byte is padded with zero bits.
+//
+//	partitioned:
+//	  base
+//	  maxN     uint64   max number of elements
+//	  p        float64  expected incidence of false positive as IEEE 754 bits
+//
+//	scalable:
+//	  m           uint64
+//	  k           uint32
+//	  n           int64
+//	  maxN        uint64
+//	  p           float64
+//	  growthRate  uint32
+//	  fpReduction float64
+//	  seed        uint64
+//	  hasher      string
+//	  keyCheck    uint64
+//	  strategy    string
+//	  stages      uint32   number of filters
+//	  partitioned of every filter
+//
+//	sharded:
+//	  counting uint8    1 for sharded counting filter
+//	  shards   uint32   number of shards
+//	  base of every shard
+
+const (
+	binaryMagic             = "BLMF"
+	binaryVersion           = 1
+	binaryVersionCompressed = 2
+)
+
+// filterType identifies filter type in binary format
+type filterType uint8
+
+const (
+	typeBloom filterType = iota + 1
+	typeCounting
+	typePartitioned
+	typeScalable
+	typeSharded
+	typeAtomic
+)
+
+var (
+	// ErrInvalidFormat is returned when data is not a filter in binary format
+	ErrInvalidFormat = errors.New("blooms: invalid binary format")
+	// ErrUnsupportedVersion is returned when data is encoded in a newer format version
+	ErrUnsupportedVersion = errors.New("blooms: unsupported binary format version")
+	// ErrChecksum is returned when checksum of data does not match
+	ErrChecksum = errors.New("blooms: checksum mismatch")
+	// ErrFilterType is returned when data holds a filter of another type
+	ErrFilterType = errors.New("blooms: data holds another filter type")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// binaryChunkSize is size of buffer to encode payload through
+const binaryChunkSize = 4096
+
+// binaryWriter writes values in binary format with running checksum.
+// The first error is kept and later writes are skipped.
+type binaryWriter struct {
+	w   io.Writer
+	crc uint32
+	n   int64
+	err error
+	buf [8]byte
+	// Whether bit maps are compressed
+	compress bool
+}
+
+func (w *binaryWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.crc = crc32.Update(w.crc, castagnoli, p[:n])
+	w.err = err
+}
+
+func (w *binaryWriter) uint8(v uint8) {
+	w.buf[0] = v
+	w.write(w.buf[:1])
+}
+
+func (w *binaryWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:4], v)
+	w.write(w.buf[:4])
+}
+
+func (w *binaryWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.write(w.buf[:])
+}
+
+func (w *binaryWriter) float64(v float64) {
+	w.uint64(math.Float64bits(v))
+}
+
+func (w *binaryWriter) string(s string) {
+	if len(s) > math.MaxUint8 {
+		if w.err == nil {
+			w.err = ErrInvalidFormat
+		}
+		return
+	}
+	w.uint8(uint8(len(s)))
+	w.write([]byte(s))
+}
+
+// words writes bit map through a fixed size buffer.
+// It is compressed when it is smaller if writer compresses.
+func (w *binaryWriter) words(words bitSet) {
+	if w.compress {
+		w.compressedWords(words)
+		return
+	}
+	w.wordsWith(len(words), func(i int) uint64 {
+		return words[i]
+	})
+}
+
+// atomicWords writes bit map updated concurrently with atomic loads.
+// Bit map is copied to be compressed, because its size must be fixed before writing.
+func (w *binaryWriter) atomicWords(words bitSet) {
+	if w.compress {
+		w.compressedWords(words.snapshot())
+		return
+	}
+	w.wordsWith(len(words), func(i int) uint64 {
+		return atomic.LoadUint64(&words[i])
+	})
+}
+
+// wordsWith writes n words got by load through a fixed size buffer
+func (w *binaryWriter) wordsWith(n int, load func(i int) uint64) {
+	var chunk [binaryChunkSize]byte
+	for i := 0; i < n && w.err == nil; {
+		c := n - i
+		if c > len(chunk)/8 {
+			c = len(chunk) / 8
+		}
+		for j := 0; j < c; j++ {
+			binary.LittleEndian.PutUint64(chunk[j*8:], load(i+j))
+		}
+		w.write(chunk[:c*8])
+		i += c
+	}
+}
+
+// counters writes counters through a fixed size buffer
+func (w *binaryWriter) counters(counters counterSet) {
+	for len(counters) > 0 && w.err == nil {
+		c := len(counters)
+		if c > binaryChunkSize {
+			c = binaryChunkSize
+		}
+		w.write(counters[:c])
+		counters = counters[c:]
+	}
+}
+
+func (w *binaryWriter) header(t filterType) {
+	w.write([]byte(binaryMagic))
+	if w.compress {
+		w.uint8(binaryVersionCompressed)
+	} else {
+		w.uint8(binaryVersion)
+	}
+	w.uint8(uint8(t))
+}
+
+func (w *binaryWriter) trailer() {
+	w.uint32(w.crc)
+}
+
+// params writes parameters of base filter with seed of digest while holding lock
+func (w *binaryWriter) params(b *baseFilter, n int, seed uint64) {
+	w.uint64(uint64(b.m))
+	w.uint32(uint32(b.k))
+	w.uint64(uint64(b.s))
+	w.uint64(uint64(n))
+	w.uint64(seed)
+	w.string(b.hasherID())
+	w.uint64(keyCheck(b.hasher))
+	w.string(b.strategyID())
+}
+
+// base writes base filter with seed of digest while holding lock
+func (w *binaryWriter) base(b *baseFilter, seed uint64) {
+	w.params(b, b.n, seed)
+	switch bits := b.bits.(type) {
+	case bitSet:
+		w.words(bits)
+	case counterSet:
+		w.counters(bits)
+	}
+}
+
+// partitioned writes partitioned filter while holding lock
+func (w *binaryWriter) partitioned(p *PartitionedFilter) {
+	w.base(p.baseFilter, p.seed)
+	w.uint64(uint64(p.maxN))
+	w.float64(p.p)
+}
+
+// binaryReader reads values in binary format with running checksum.
+// The first error is kept and later reads return zero.
+type binaryReader struct {
+	r   io.Reader
+	crc uint32
+	n   int64
+	err error
+	buf [8]byte
+	// Format version of data
+	version uint8
+}
+
+func (r *binaryReader) read(p []byte) bool {
+	if r.err != nil {
+		return false
+	}
+	n, err := io.ReadFull(r.r, p)
+	r.n += int64(n)
+	r.crc = crc32.Update(r.crc, castagnoli, p[:n])
+	if err != nil {
+		// Stream ending in the middle of filter is unexpected
+		if err == io.EOF && r.n != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// fail keeps err unless another error has been kept
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *binaryReader) uint8() uint8 {
+	if !r.read(r.buf[:1]) {
+		return 0
+	}
+	return r.buf[0]
+}
+
+func (r *binaryReader) uint32() uint32 {
+	if !r.read(r.buf[:4]) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(r.buf[:4])
+}
+
+func (r *binaryReader) uint64() uint64 {
+	if !r.read(r.buf[:]) {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.buf[:])
+}
+
+func (r *binaryReader) float64() float64 {
+	return math.Float64frombits(r.uint64())
+}
+
+func (r *binaryReader) string() string {
+	p := make([]byte, r.uint8())
+	if !r.read(p) {
+		return ""
+	}
+	return string(p)
+}
+
+// size reads uint64 of size which is -1 unless it is within maxSlots
+func (r *binaryReader) size() int {
+	v := r.uint64()
+	if v > uint64(maxSlots) {
+		return -1
+	}
+	return int(v)
+}
+
+// holds checks if data holds size bytes more when its length is known,
+// so that a forged size never allocates more than data
+func (r *binaryReader) holds(size int) bool {
+	if l, ok := r.r.(interface{ Len() int }); ok && l.Len() < size {
+		r.fail(io.ErrUnexpectedEOF)
+		return false
+	}
+	return r.err == nil
+}
+
+// words reads n words of bit map through a fixed size buffer.
+// Bit map is allocated once, so that reading needs no more than the buffer besides it.
+func (r *binaryReader) words(n int) bitSet {
+	if !r.holds(n * 8) {
+		return nil
+	}
+	words := make(bitSet, n)
+	var chunk [binaryChunkSize]byte
+	for i := 0; i < n; i += len(chunk) / 8 {
+		c := words[i:]
+		if len(c) > len(chunk)/8 {
+			c = c[:len(chunk)/8]
+		}
+		if !r.read(chunk[:len(c)*8]) {
+			return nil
+		}
+		for j := range c {
+			c[j] = binary.LittleEndian.Uint64(chunk[j*8:])
+		}
+	}
+	return words
+}
+
+// counters reads n counters, which are allocated once and read in place
+func (r *binaryReader) counters(n int) counterSet {
+	if !r.holds(n) {
+		return nil
+	}
+	counters := make(counterSet, n)
+	for i := 0; i < n; i += binaryChunkSize {
+		c := counters[i:]
+		if len(c) > binaryChunkSize {
+			c = c[:binaryChunkSize]
+		}
+		if !r.read(c) {
+			return nil
+		}
+	}
+	return counters
+}
+
+func (r *binaryReader) header(t filterType) {
+	magic := make([]byte, len(binaryMagic))
+	if !r.read(magic) {
+		return
+	}
+	if string(magic) != binaryMagic {
+		r.fail(ErrInvalidFormat)
+		return
+	}
+	r.version = r.uint8()
+	if r.version != binaryVersion && r.version != binaryVersionCompressed {
+		r.fail(ErrUnsupportedVersion)
+		return
+	}
+	if filterType(r.uint8()) != t {
+		r.fail(ErrFilterType)
+	}
+}
+
+func (r *binaryReader) trailer() {
+	crc := r.crc
+	if r.uint32() != crc {
+		r.fail(ErrChecksum)
+	}
+}
+
+// base reads base filter with seed of digest.
+// Parameters are validated before reading payload.
+func (r *binaryReader) base(counting bool) (*baseFilter, uint64) {
+	m := r.size()
+	k := int(r.uint32())
+	s := r.size()
+	n := int(int64(r.uint64()))
+	seed := r.uint64()
+	hasherID := r.string()
+	check := r.uint64()
+	strategyID := r.string()
+	if r.err != nil {
+		return nil, 0
+	}
+
+	hasher, err := lookupHasher(hasherID)
+	if err != nil {
+		r.fail(err)
+		return nil, 0
+	}
+	strategy, err := lookupIndexStrategy(strategyID)
+	if err != nil {
+		r.fail(err)
+		return nil, 0
+	}
+
+	b := &baseFilter{
+		m:        m,
+		k:        k,
+		n:        n,
+		s:        s,
+		hasher:   withKeyCheck(hasher, check),
+		strategy: strategy,
+	}
+	err = b.validateParams()
+	if err != nil {
+		r.fail(err)
+		return nil, 0
+	}
+	switch {
+	case counting:
+		b.bits = r.counters(m)
+	case r.version == binaryVersionCompressed:
+		b.bits = r.compressedWords(m)
+	default:
+		b.bits = r.words(wordNumber(m))
+	}
+	if r.err != nil {
+		return nil, 0
+	}
+	return b, seed
+}
+
+// partitioned reads partitioned filter
+func (r *binaryReader) partitioned() *PartitionedFilter {
+	base, seed := r.base(false)
+	maxN := r.size()
+	p := r.float64()
+	if r.err != nil {
+		return nil
+	}
+	pf := &PartitionedFilter{
+		baseFilter: base,
+		maxN:       maxN,
+		p:          p,
+		seed:       seed,
+	}
+	err := pf.validate()
+	if err != nil {
+		r.fail(err)
+		return nil
+	}
+	return pf
+}
+
+// writeBinary writes header, body written by body and trailer to w.
+// Bit maps are compressed when they are smaller if compress is set.
+func writeBinary(w io.Writer, t filterType, compress bool, body func(w *binaryWriter)) (int64, error) {
+	bw := &binaryWriter{w: w, compress: compress}
+	bw.header(t)
+	body(bw)
+	bw.trailer()
+	return bw.n, bw.err
+}
+
+// readBinary reads header, body read by body and trailer from r.
+// Unless r holds a whole filter, nothing must follow filter in r.
+func readBinary(r io.Reader, whole bool, t filterType, body func(r *binaryReader)) (int64, error) {
+	br := &binaryReader{r: r}
+	br.header(t)
+	body(br)
+	br.trailer()
+	if whole && br.err == nil {
+		var b [1]byte
+		if n, _ := r.Read(b[:]); n != 0 {
+			br.err = ErrInvalidFormat
+		}
+	}
+	if whole && (br.err == io.EOF || br.err == io.ErrUnexpectedEOF) {
+		br.err = ErrInvalidFormat
+	}
+	return br.n, br.err
+}
+
+// marshalBinary encodes filter with write
+func marshalBinary(write func(w io.Writer) (int64, error)) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := write(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes filter to w in binary format.
+// Filter is read locked while writing, and bit map is streamed through a fixed size buffer.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return b.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Every bit map is coded with Golomb-Rice coding when it is smaller than raw words.
+func (b *BloomFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	return b.writeTo(w, true)
+}
+
+func (b *BloomFilter) writeTo(w io.Writer, compress bool) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return writeBinary(w, typeBloom, compress, func(bw *binaryWriter) {
+		bw.base(b.baseFilter, 0)
+	})
+}
+
+// ReadFrom reads filter in binary format from r.
+// It reads nothing after filter, so filters can be read one after another.
+func (b *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	return b.readFrom(r, false)
+}
+
+func (b *BloomFilter) readFrom(r io.Reader, whole bool) (int64, error) {
+	var base *baseFilter
+	n, err := readBinary(r, whole, typeBloom, func(br *binaryReader) {
+		base, _ = br.base(false)
+	})
+	if err != nil {
+		return n, err
+	}
+	err = setBase(&b.baseFilter, base)
+	return n, err
+}
+
+// MarshalBinary encodes filter in binary format
+func (b *BloomFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(b.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (b *BloomFilter) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(b.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (b *BloomFilter) UnmarshalBinary(data []byte) error {
+	_, err := b.readFrom(bytes.NewReader(data), true)
+	return err
+}
+
+// WriteTo writes filter to w in binary format.
+// Filter is read locked while writing, and counters are streamed through a fixed size buffer.
+func (c *CountingFilter) WriteTo(w io.Writer) (int64, error) {
+	return c.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Counters are never compressed, so it differs from WriteTo only in format version.
+func (c *CountingFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	return c.writeTo(w, true)
+}
+
+func (c *CountingFilter) writeTo(w io.Writer, compress bool) (int64, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return writeBinary(w, typeCounting, compress, func(bw *binaryWriter) {
+		bw.base(c.baseFilter, 0)
+	})
+}
+
+// ReadFrom reads filter in binary format from r
+func (c *CountingFilter) ReadFrom(r io.Reader) (int64, error) {
+	return c.readFrom(r, false)
+}
+
+func (c *CountingFilter) readFrom(r io.Reader, whole bool) (int64, error) {
+	var base *baseFilter
+	n, err := readBinary(r, whole, typeCounting, func(br *binaryReader) {
+		base, _ = br.base(true)
+	})
+	if err != nil {
+		return n, err
+	}
+	err = setBase(&c.baseFilter, base)
+	return n, err
+}
+
+// MarshalBinary encodes filter in binary format
+func (c *CountingFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(c.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (c *CountingFilter) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(c.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (c *CountingFilter) UnmarshalBinary(data []byte) error {
+	_, err := c.readFrom(bytes.NewReader(data), true)
+	return err
+}
+
+// WriteTo writes filter to w in binary format.
+// Bits are loaded atomically while writing, so concurrent Add is not blocked
+// and bits set during writing may or may not be included.
+func (a *AtomicFilter) WriteTo(w io.Writer) (int64, error) {
+	return a.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Every bit map is coded with Golomb-Rice coding when it is smaller than raw words.
+func (a *AtomicFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	return a.writeTo(w, true)
+}
+
+func (a *AtomicFilter) writeTo(w io.Writer, compress bool) (int64, error) {
+	return writeBinary(w, typeAtomic, compress, func(bw *binaryWriter) {
+		bw.params(a.baseFilter, int(a.Count()), 0)
+		bw.atomicWords(a.bits.(bitSet))
+	})
+}
+
+// ReadFrom reads filter in binary format from r.
+// It must not be called concurrently with other methods.
+func (a *AtomicFilter) ReadFrom(r io.Reader) (int64, error) {
+	return a.readFrom(r, false)
+}
+
+func (a *AtomicFilter) readFrom(r io.Reader, whole bool) (int64, error) {
+	var base *baseFilter
+	n, err := readBinary(r, whole, typeAtomic, func(br *binaryReader) {
+		base, _ = br.base(false)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, a.load(base)
+}
+
+// MarshalBinary encodes a snapshot of filter in binary format
+func (a *AtomicFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(a.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (a *AtomicFilter) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(a.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format.
+// It must not be called concurrently with other methods.
+func (a *AtomicFilter) UnmarshalBinary(data []byte) error {
+	_, err := a.readFrom(bytes.NewReader(data), true)
+	return err
+}
+
+// WriteTo writes filter to w in binary format.
+// Filter is read locked while writing, and bit map is streamed through a fixed size buffer.
+func (p *PartitionedFilter) WriteTo(w io.Writer) (int64, error) {
+	return p.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Every bit map is coded with Golomb-Rice coding when it is smaller than raw words.
+func (p *PartitionedFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	return p.writeTo(w, true)
+}
+
+func (p *PartitionedFilter) writeTo(w io.Writer, compress bool) (int64, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return writeBinary(w, typePartitioned, compress, func(bw *binaryWriter) {
+		bw.partitioned(p)
+	})
+}
+
+// ReadFrom reads filter in binary format from r
+func (p *PartitionedFilter) ReadFrom(r io.Reader) (int64, error) {
+	return p.readFrom(r, false)
+}
+
+func (p *PartitionedFilter) readFrom(r io.Reader, whole bool) (int64, error) {
+	var pf *PartitionedFilter
+	n, err := readBinary(r, whole, typePartitioned, func(br *binaryReader) {
+		pf = br.partitioned()
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, p.load(pf)
+}
+
+// MarshalBinary encodes filter in binary format
+func (p *PartitionedFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(p.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (p *PartitionedFilter) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(p.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (p *PartitionedFilter) UnmarshalBinary(data []byte) error {
+	_, err := p.readFrom(bytes.NewReader(data), true)
+	return err
+}
+
+// WriteTo writes filter to w in binary format filter by filter.
+// Filter is read locked while writing, and bit maps are streamed through a fixed size buffer.
+func (sf *ScalableFilter) WriteTo(w io.Writer) (int64, error) {
+	return sf.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Every bit map is coded with Golomb-Rice coding when it is smaller than raw words.
+func (sf *ScalableFilter) WriteCompressedTo(w io.Writer) (int64, error) {
+	return sf.writeTo(w, true)
+}
+
+func (sf *ScalableFilter) writeTo(w io.Writer, compress bool) (int64, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return writeBinary(w, typeScalable, compress, func(bw *binaryWriter) {
+		bw.uint64(uint64(sf.m))
+		bw.uint32(uint32(sf.k))
+		bw.uint64(uint64(sf.n))
+		bw.uint64(uint64(sf.maxN))
+		bw.float64(sf.p)
+		bw.uint32(uint32(sf.growthRate))
+		bw.float64(sf.fpReduction)
+		bw.uint64(sf.seed)
+		bw.string(sf.hasher.ID())
+		bw.uint64(keyCheck(sf.hasher))
+		bw.string(sf.strategy.ID())
+		bw.uint32(uint32(len(sf.filters)))
+		for _, pf := range sf.filters {
+			pf.mu.RLock()
+			bw.partitioned(pf)
+			pf.mu.RUnlock()
+		}
+	})
+}
+
+// ReadFrom reads filter in binary format from r filter by filter
+func (sf *ScalableFilter) ReadFrom(r io.Reader) (int64, error) {
+	return sf.readFrom(r, false)
+}
+
+func (sf *ScalableFilter) readFrom(r io.Reader, whole bool) (int64, error) {
+	d := &ScalableFilter{}
+	var hasherID, strategyID string
+	var check uint64
+	n, err := readBinary(r, whole, typeScalable, func(br *binaryReader) {
+		d.m = br.size()
+		d.k = int(br.uint32())
+		d.n = int64(br.uint64())
+		d.maxN = br.size()
+		d.p = br.float64()
+		d.growthRate = int(br.uint32())
+		d.fpReduction = br.float64()
+		d.seed = br.uint64()
+		hasherID = br.string()
+		check = br.uint64()
+		strategyID = br.string()
+		stages := int(br.uint32())
+		for i := 0; i < stages && br.err == nil; i++ {
+			d.filters = append(d.filters, br.partitioned())
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+
+	hasher, err := lookupHasher(hasherID)
+	if err != nil {
+		return n, err
+	}
+	strategy, err := lookupIndexStrategy(strategyID)
+	if err != nil {
+		return n, err
+	}
+	d.hasher = withKeyCheck(hasher, check)
+	d.strategy = strategy
+	err = d.validate()
+	if err != nil {
+		return n, err
+	}
+	return n, sf.load(d)
+}
+
+// MarshalBinary encodes filter in binary format
+func (sf *ScalableFilter) MarshalBinary() ([]byte, error) {
+	return marshalBinary(sf.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (sf *ScalableFilter) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(sf.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (sf *ScalableFilter) UnmarshalBinary(data []byte) error {
+	_, err := sf.readFrom(bytes.NewReader(data), true)
+	return err
+}
+
+// WriteTo writes filter to w in binary format shard by shard.
+// All shards are read locked while writing to take a consistent snapshot.
+func (sf *shardedBase) WriteTo(w io.Writer) (int64, error) {
+	return sf.writeTo(w, false)
+}
+
+// WriteCompressedTo writes filter to w in compressed binary format.
+// Every bit map is coded with Golomb-Rice coding when it is smaller than raw words.
+func (sf *shardedBase) WriteCompressedTo(w io.Writer) (int64, error) {
+	return sf.writeTo(w, true)
+}
+
+func (sf *shardedBase) writeTo(w io.Writer, compress bool) (int64, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	for _, s := range sf.shards {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return writeBinary(w, typeSharded, compress, func(bw *binaryWriter) {
+		var counting uint8
+		if sf.counting {
+			counting = 1
+		}
+		bw.uint8(counting)
+		bw.uint32(uint32(len(sf.shards)))
+		for _, s := range sf.shards {
+			bw.base(s, 0)
+		}
+	})
+}
+
+// ReadFrom reads filter in binary format from r shard by shard
+func (sf *ShardedFilter) ReadFrom(r io.Reader) (int64, error) {
+	return sf.readFrom(r, false, false)
+}
+
+// ReadFrom reads filter in binary format from r shard by shard
+func (sf *ShardedCountingFilter) ReadFrom(r io.Reader) (int64, error) {
+	return sf.readFrom(r, false, true)
+}
+
+// readFrom reads sharded filter whose shards are counting or not
+func (sf *shardedBase) readFrom(r io.Reader, whole, counting bool) (int64, error) {
+	var shards []*baseFilter
+	n, err := readBinary(r, whole, typeSharded, func(br *binaryReader) {
+		if (br.uint8() != 0) != counting {
+			br.fail(ErrFilterType)
+			return
+		}
+		shardNumber := int(br.uint32())
+		for i := 0; i < shardNumber && br.err == nil; i++ {
+			s, _ := br.base(counting)
+			shards = append(shards, s)
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+	d := &shardedBase{shards: shards, counting: counting}
+	err = d.validate()
+	if err != nil {
+		return n, err
+	}
+	return n, sf.load(d)
+}
+
+// MarshalBinary encodes filter in binary format
+func (sf *shardedBase) MarshalBinary() ([]byte, error) {
+	return marshalBinary(sf.WriteTo)
+}
+
+// MarshalCompressed encodes filter in compressed binary format
+func (sf *shardedBase) MarshalCompressed() ([]byte, error) {
+	return marshalBinary(sf.WriteCompressedTo)
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (sf *ShardedFilter) UnmarshalBinary(data []byte) error {
+	_, err := sf.readFrom(bytes.NewReader(data), true, false)
+	return err
+}
+
+// UnmarshalBinary decodes filter in binary format
+func (sf *ShardedCountingFilter) UnmarshalBinary(data []byte) error {
+	_, err := sf.readFrom(bytes.NewReader(data), true, true)
+	return err
+}
@@ -0,0 +1,290 @@
+package blooms
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenVector is a filter encoded to testdata for readers in other languages
+type goldenVector struct {
+	name     string
+	elements []string
+	filter   func() Serializable
+	// Whether bit maps are compressed
+	compressed bool
+}
+
+func (v goldenVector) marshal(f Serializable) ([]byte, error) {
+	if v.compressed {
+		return f.MarshalCompressed()
+	}
+	return f.MarshalBinary()
+}
+
+func elementsOf(n int) []string {
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = fmt.Sprint("element-", i)
+	}
+	return elements
+}
+
+var goldenVectors = []goldenVector{
+	{"bloom", []string{"a", "b", "c"}, func() Serializable { return New(128, 3) }, false},
+	{"counting", []string{"a", "b", "b"}, func() Serializable { return NewCountingFilter(64, 3) }, false},
+	{"partitioned", []string{"a", "b", "c"}, func() Serializable { return NewPartitionedFilter(128, 3) }, false},
+	{"scalable", elementsOf(20), func() Serializable { return NewScalableFilter(64, 2, 0.1, 0.5, WithSeed(42)) }, false},
+	{"sharded", []string{"a", "b", "c"}, func() Serializable { return NewShardedFilter(2, 64, 3) }, false},
+	{"atomic", []string{"a", "b", "c"}, func() Serializable { return NewAtomicFilter(128, 3) }, false},
+}
+
+// compressedGoldenVectors are sparse enough to be coded with Golomb-Rice coding
+var compressedGoldenVectors = []goldenVector{
+	{"bloom-compressed", []string{"a", "b", "c"}, func() Serializable { return New(4096, 3) }, true},
+	{"scalable-compressed", elementsOf(20), func() Serializable { return NewScalableFilter(1024, 2, 0.1, 0.5, WithSeed(42)) }, true},
+}
+
+func TestMarshalBinary_Golden(t *testing.T) {
+	Convey("Given filters of golden vectors", t, func() {
+		for _, v := range append(goldenVectors, compressedGoldenVectors...) {
+			f := v.filter()
+			for _, e := range v.elements {
+				f.Add([]byte(e))
+			}
+			path := filepath.Join("testdata", v.name+".bin")
+
+			Convey(fmt.Sprintf("When encoding %s filter", v.name), func() {
+				data, err := v.marshal(f)
+				So(err, ShouldBeNil)
+				if *update {
+					So(ioutil.WriteFile(path, data, 0644), ShouldBeNil)
+				}
+
+				Convey("Then it should equal golden vector", func() {
+					golden, err := ioutil.ReadFile(path)
+					So(err, ShouldBeNil)
+					So(bytes.Equal(data, golden), ShouldBeTrue)
+
+				})
+			})
+
+			Convey(fmt.Sprintf("When decoding golden vector of %s filter", v.name), func() {
+				golden, err := ioutil.ReadFile(path)
+				So(err, ShouldBeNil)
+				d := v.filter()
+				err = d.UnmarshalBinary(golden)
+
+				Convey("Then it should have every element", func() {
+					So(err, ShouldBeNil)
+					for _, e := range v.elements {
+						So(d.Has([]byte(e)), ShouldBeTrue)
+					}
+					So(d.(Estimator).Count(), ShouldEqual, len(v.elements))
+					data, err := v.marshal(d)
+					So(err, ShouldBeNil)
+					So(bytes.Equal(data, golden), ShouldBeTrue)
+
+				})
+			})
+		}
+	})
+}
+
+func TestMarshalBinary_Keyed(t *testing.T) {
+	Convey("Given keyed filter", t, func() {
+		key := [16]byte{1, 2, 3}
+		b := New(128, 3, WithHasher(NewSipHasher(key)))
+		b.Add([]byte("a"))
+
+		Convey("When decoding it", func() {
+			data, err := b.MarshalBinary()
+			So(err, ShouldBeNil)
+			var d BloomFilter
+			derr := d.UnmarshalBinary(data)
+			keyed := New(1, 1, WithHasher(NewSipHasher(key)))
+			kerr := keyed.UnmarshalBinary(data)
+			other := New(1, 1, WithHasher(NewSipHasher([16]byte{9})))
+			oerr := other.UnmarshalBinary(data)
+
+			Convey("Then it should take only the key it was built with", func() {
+				So(derr, ShouldEqual, ErrMissingKey)
+				So(kerr, ShouldBeNil)
+				So(keyed.Has([]byte("a")), ShouldBeTrue)
+				So(oerr, ShouldEqual, ErrKeyMismatch)
+
+			})
+		})
+	})
+}
+
+func TestUnmarshalBinary_Invalid(t *testing.T) {
+	Convey("Given encoded bloom filter", t, func() {
+		b := New(128, 3)
+		b.Add([]byte("a"))
+		data, err := b.MarshalBinary()
+		So(err, ShouldBeNil)
+		corrupt := func(i int, v byte) []byte {
+			c := append([]byte(nil), data...)
+			c[i] = v
+			return c
+		}
+
+		Convey("When decoding corrupted data", func() {
+			cases := []struct {
+				name string
+				data []byte
+				err  error
+			}{
+				{"magic", corrupt(0, 'X'), ErrInvalidFormat},
+				{"version", corrupt(4, binaryVersionCompressed+1), ErrUnsupportedVersion},
+				{"type", corrupt(5, byte(typeCounting)), ErrFilterType},
+				{"payload", corrupt(len(data)-5, 0xFF), ErrChecksum},
+				{"truncated", data[:len(data)-1], ErrInvalidFormat},
+				{"trailing", append(append([]byte(nil), data...), 0), ErrInvalidFormat},
+			}
+			for _, c := range cases {
+				var d BloomFilter
+				err := d.UnmarshalBinary(c.data)
+
+				Convey(fmt.Sprintf("Then error should be returned for %s", c.name), func() {
+					So(err, ShouldEqual, c.err)
+
+				})
+			}
+		})
+	})
+}
+
+func TestWriteTo(t *testing.T) {
+	Convey("Given filters of every type", t, func() {
+		filters := []Serializable{
+			New(1024, 3),
+			NewCountingFilter(1024, 3),
+			NewPartitionedFilter(1024, 3),
+			NewScalableFilter(64, 2, 0.1, 0.5),
+			NewShardedCountingFilter(3, 256, 3),
+			NewAtomicFilter(1024, 3),
+		}
+		elements := elementsOf(100)
+		for _, f := range filters {
+			for _, e := range elements {
+				f.Add([]byte(e))
+			}
+		}
+
+		Convey("When writing them one after another to a stream", func() {
+			var buf bytes.Buffer
+			var written int64
+			for _, f := range filters {
+				n, err := f.WriteTo(&buf)
+				So(err, ShouldBeNil)
+				written += n
+			}
+			So(written, ShouldEqual, buf.Len())
+
+			Convey("Then they should be read back one after another", func() {
+				decoded := []Serializable{
+					&BloomFilter{},
+					&CountingFilter{},
+					&PartitionedFilter{},
+					&ScalableFilter{},
+					&ShardedCountingFilter{},
+					&AtomicFilter{},
+				}
+				var read int64
+				for i, d := range decoded {
+					n, err := d.ReadFrom(&buf)
+					So(err, ShouldBeNil)
+					read += n
+					for _, e := range elements {
+						So(d.Has([]byte(e)), ShouldBeTrue)
+					}
+					So(d.(Estimator).Count(), ShouldEqual, filters[i].(Estimator).Count())
+				}
+				So(read, ShouldEqual, written)
+
+				_, err := (&BloomFilter{}).ReadFrom(&buf)
+				So(err, ShouldEqual, io.EOF)
+
+			})
+		})
+
+		Convey("When reading a stream ending in the middle of filter", func() {
+			data, err := filters[0].MarshalBinary()
+			So(err, ShouldBeNil)
+			_, err = (&BloomFilter{}).ReadFrom(bytes.NewReader(data[:len(data)/2]))
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, io.ErrUnexpectedEOF)
+
+			})
+		})
+	})
+}
+
+func TestWriteTo_Memory(t *testing.T) {
+	Convey("Given large bloom filter", t, func() {
+		m := 1 << 25
+		b := New(m, 3)
+		for i := 0; i < 1000; i++ {
+			b.Add([]byte(fmt.Sprint(i)))
+		}
+		var stats runtime.MemStats
+
+		Convey("When writing it to a stream", func() {
+			runtime.ReadMemStats(&stats)
+			before := stats.TotalAlloc
+			n, err := b.WriteTo(ioutil.Discard)
+			runtime.ReadMemStats(&stats)
+			allocated := stats.TotalAlloc - before
+
+			Convey("Then extra memory should be bounded", func() {
+				So(err, ShouldBeNil)
+				So(n, ShouldBeGreaterThan, m/8)
+				So(allocated, ShouldBeLessThan, 64<<10)
+
+			})
+		})
+
+		Convey("When reading it from a stream", func() {
+			var buf bytes.Buffer
+			b.WriteTo(&buf)
+			counting := NewCountingFilter(m/8, 3)
+			var cbuf bytes.Buffer
+			counting.WriteTo(&cbuf)
+			res := &BloomFilter{}
+			cres := &CountingFilter{}
+
+			runtime.ReadMemStats(&stats)
+			before := stats.TotalAlloc
+			_, err := res.ReadFrom(bytes.NewReader(buf.Bytes()))
+			runtime.ReadMemStats(&stats)
+			allocated := stats.TotalAlloc - before
+
+			runtime.ReadMemStats(&stats)
+			before = stats.TotalAlloc
+			_, cerr := cres.ReadFrom(bytes.NewReader(cbuf.Bytes()))
+			runtime.ReadMemStats(&stats)
+			callocated := stats.TotalAlloc - before
+
+			Convey("Then extra memory besides the filter should be bounded", func() {
+				So(err, ShouldBeNil)
+				So(res.Has([]byte("0")), ShouldBeTrue)
+				So(allocated, ShouldBeLessThan, m/8+64<<10)
+				So(cerr, ShouldBeNil)
+				So(callocated, ShouldBeLessThan, m/8+64<<10)
+
+			})
+		})
+	})
+}
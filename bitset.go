@@ -0,0 +1,180 @@
+package blooms
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+const wordSize = 64
+
+// storage is slot array backing a filter
+type storage interface {
+	// set marks a slot
+	set(i int)
+	// test checks if a slot is marked
+	test(i int) bool
+	// clone copies slots
+	clone() storage
+}
+
+// bitSet is bit map packed into 64bit words
+type bitSet []uint64
+
+// newBitSet creates a bit set which can hold m bits
+func newBitSet(m int) bitSet {
+	return make(bitSet, wordNumber(m))
+}
+
+// wordNumber gets the number of words to hold m bits
+func wordNumber(m int) int {
+	return (m + wordSize - 1) / wordSize
+}
+
+func (bs bitSet) set(i int) {
+	bs[i/wordSize] |= 1 << uint(i%wordSize)
+}
+
+func (bs bitSet) test(i int) bool {
+	return bs[i/wordSize]&(1<<uint(i%wordSize)) != 0
+}
+
+func (bs bitSet) clone() storage {
+	return append(bitSet(nil), bs...)
+}
+
+// merge sets all bits of other
+func (bs bitSet) merge(other bitSet) {
+	for i := range bs {
+		bs[i] |= other[i]
+	}
+}
+
+// intersect clears all bits which are not set in other
+func (bs bitSet) intersect(other bitSet) {
+	for i := range bs {
+		bs[i] &= other[i]
+	}
+}
+
+// count gets the number of set bits
+func (bs bitSet) count() int {
+	var c int
+	for _, w := range bs {
+		c += bits.OnesCount64(w)
+	}
+	return c
+}
+
+// unionCount gets the number of bits set in either of bs and other
+func (bs bitSet) unionCount(other bitSet) int {
+	var c int
+	for i := range bs {
+		c += bits.OnesCount64(bs[i] | other[i])
+	}
+	return c
+}
+
+// fold ORs every bit i into bit i%size of a new bit set
+func (bs bitSet) fold(size int) bitSet {
+	folded := newBitSet(size)
+	for w, word := range bs {
+		for word != 0 {
+			i := w*wordSize + bits.TrailingZeros64(word)
+			folded.set(i % size)
+			word &= word - 1
+		}
+	}
+	return folded
+}
+
+// setAtomic sets a bit with CAS loop, so concurrent writers never lose bits
+func (bs bitSet) setAtomic(i int) {
+	addr := &bs[i/wordSize]
+	mask := uint64(1) << uint(i%wordSize)
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return
+		}
+	}
+}
+
+// testAtomic checks a bit with atomic load
+func (bs bitSet) testAtomic(i int) bool {
+	return atomic.LoadUint64(&bs[i/wordSize])&(1<<uint(i%wordSize)) != 0
+}
+
+// snapshot copies bit set with atomic loads
+func (bs bitSet) snapshot() bitSet {
+	dst := make(bitSet, len(bs))
+	for i := range bs {
+		dst[i] = atomic.LoadUint64(&bs[i])
+	}
+	return dst
+}
+
+// fromBytes packs a legacy bit map which has a byte per bit
+func (bs bitSet) fromBytes(bytes []uint8) bitSet {
+	for i := range bytes {
+		if bytes[i] != 0 {
+			bs.set(i)
+		}
+	}
+	return bs
+}
+
+// counterSet is counter map which has a uint8 counter per slot
+type counterSet []uint8
+
+// newCounterSet creates a counter set which has m counters
+func newCounterSet(m int) counterSet {
+	return make(counterSet, m)
+}
+
+// set increments counter up to 255
+func (cs counterSet) set(i int) {
+	if cs[i] < 0xFF {
+		cs[i]++
+	}
+}
+
+func (cs counterSet) test(i int) bool {
+	return cs[i] != 0
+}
+
+func (cs counterSet) clone() storage {
+	return append(counterSet(nil), cs...)
+}
+
+// merge adds counters of other up to 255
+func (cs counterSet) merge(other counterSet) {
+	for i := range cs {
+		sum := int(cs[i]) + int(other[i])
+		if sum > 0xFF {
+			sum = 0xFF
+		}
+		cs[i] = uint8(sum)
+	}
+}
+
+// subtract subtracts counters of other down to 0.
+// Saturated counters are left as they are, because their true counts are unknown.
+func (cs counterSet) subtract(other counterSet) {
+	for i := range cs {
+		if cs[i] == 0xFF {
+			continue
+		}
+		if cs[i] < other[i] {
+			cs[i] = 0
+		} else {
+			cs[i] -= other[i]
+		}
+	}
+}
+
+// unset decrements counter down to 0
+func (cs counterSet) unset(i int) {
+	if cs[i] > 0 {
+		cs[i]--
+	}
+}
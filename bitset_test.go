@@ -0,0 +1,82 @@
+package blooms
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBitSet(t *testing.T) {
+	Convey("Given bit set", t, func() {
+		bs := newBitSet(130)
+
+		Convey("When setting bits across words", func() {
+			bs.set(0)
+			bs.set(64)
+			bs.set(129)
+
+			Convey("Then only set bits should be marked", func() {
+				So(len(bs), ShouldEqual, 3)
+				So(bs.test(0), ShouldBeTrue)
+				So(bs.test(64), ShouldBeTrue)
+				So(bs.test(129), ShouldBeTrue)
+				So(bs.test(1), ShouldBeFalse)
+				So(bs.test(63), ShouldBeFalse)
+				So(bs[0], ShouldEqual, 1)
+				So(bs[2], ShouldEqual, 2)
+
+			})
+		})
+
+		Convey("When packing bytes", func() {
+			bs = bs.fromBytes([]uint8{0, 3, 0, 1})
+
+			Convey("Then non zero bytes should be set", func() {
+				So(bs[0], ShouldEqual, 10)
+
+			})
+		})
+	})
+}
+
+func TestCounterSet(t *testing.T) {
+	Convey("Given counter set", t, func() {
+		cs := newCounterSet(4)
+
+		Convey("When setting a counter over max", func() {
+			for i := 0; i < 300; i++ {
+				cs.set(1)
+			}
+
+			Convey("Then counter should saturate", func() {
+				So(cs[1], ShouldEqual, 0xFF)
+				So(cs.test(1), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging and subtracting counters", func() {
+			cs[0], cs[1], cs[2] = 200, 3, 0xFF
+			other := counterSet{100, 5, 1, 0}
+			sum := cs.clone().(counterSet)
+			sum.merge(other)
+			cs.subtract(other)
+
+			Convey("Then counters should saturate at both ends", func() {
+				So(sum, ShouldResemble, counterSet{0xFF, 8, 0xFF, 0})
+				So(cs, ShouldResemble, counterSet{100, 0, 0xFF, 0})
+
+			})
+		})
+
+		Convey("When unsetting an empty counter", func() {
+			cs.unset(2)
+
+			Convey("Then counter should stay 0", func() {
+				So(cs[2], ShouldEqual, 0)
+				So(cs.test(2), ShouldBeFalse)
+
+			})
+		})
+	})
+}
@@ -3,31 +3,56 @@ package blooms
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"math"
 	"sync"
+)
 
-	"github.com/spaolacci/murmur3"
+var (
+	// ErrInvalidFoldFactor is returned when filter size is not a multiple of fold factor,
+	// or folded size is less than the number of hash functions
+	ErrInvalidFoldFactor = errors.New("blooms: invalid fold factor")
+	// ErrNotFoldable is returned when index strategy of filter does not stay valid after folding
+	ErrNotFoldable = errors.New("blooms: index strategy is not foldable")
 )
 
 // baseFilter is base for variety of filters
 type baseFilter struct {
 	mu sync.RWMutex
-	// Bit map slice
-	bits []uint8
+	// Slots of bit map or counters
+	bits storage
+	// Number of slots
+	m int
 	// Number of hash functions
 	k int
 	// Number of elements
 	n int
 	// Number of element per a slice
 	s int
+	// Hash function
+	hasher Hasher
+	// Derivation of indices from hash
+	strategy IndexStrategy
 }
 
 // baseGobs is gob stream receiver
 type baseGobs struct {
+	// Counters for counting filter.
+	// Streams encoded before bit packing also hold a byte per bit here.
 	Bits []uint8
-	K    int
-	N    int
-	S    int
+	// Packed bit map
+	Words []uint64
+	M     int
+	K     int
+	N     int
+	S     int
+	// ID of hash function
+	Hasher string
+	// Tag of key for keyed hash function
+	KeyCheck uint64
+	// ID of index strategy
+	Strategy string
 }
 
 // gobEncode encodes filter to gob stream
@@ -53,84 +78,346 @@ func gobDecode(data []byte, dst interface{}) error {
 }
 
 func divideHash(h uint64) (h1 uint32, h2 uint32) {
-	// Get first half                                                                                                x
+	// Get first half
 	h1 = uint32(h >> 32)
 	// Get later half
 	h2 = uint32(h & ((1 << 32) - 1))
 	return
 }
 
-func getIndex(h1, h2 uint32, i, size int) int {
-	return int(h1+uint32(i)*h2) % size
+// createHash creats digest of element
+func (b *baseFilter) createHash(element []byte) Digest {
+	if b.hasher == nil {
+		return HashOf(element)
+	}
+	return HashWith(b.hasher, element)
 }
 
-// createHash creats 64bit hash
-func (b *baseFilter) createHash(element []byte) uint64 {
-	hasher := murmur3.New64()
-	hasher.Reset()
-	hasher.Write(element)
-	return hasher.Sum64()
+// hasherID gets ID of hash function
+func (b *baseFilter) hasherID() string {
+	if b.hasher == nil {
+		return DefaultHasher.ID()
+	}
+	return b.hasher.ID()
 }
 
-// Add adds a new element into bloomfilter
-func (b *baseFilter) Add(element []byte) {
-	h := b.createHash(element)
-	h1, h2 := divideHash(h)
-	size := len(b.bits)
+// strategyID gets ID of index strategy
+func (b *baseFilter) strategyID() string {
+	if b.strategy == nil {
+		return DefaultIndexStrategy.ID()
+	}
+	return b.strategy.ID()
+}
+
+// location gets slot of i-th hash function
+func (b *baseFilter) location(d Digest, i int) int {
+	size := b.m
 	// For partitioned filter
 	if b.s != 0 {
 		size = b.s
 	}
+	strategy := b.strategy
+	if strategy == nil {
+		strategy = DefaultIndexStrategy
+	}
+	return strategy.Index(d.h1, d.h2, i, size) + (i * b.s)
+}
+
+// Add adds a new element into bloomfilter
+func (b *baseFilter) Add(element []byte) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.addHash(b.createHash(element))
+}
+
+// AddHash adds a new element by its digest.
+// Digest must be computed with the same hasher as filter.
+func (b *baseFilter) AddHash(d Digest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addHash(d)
+}
+
+// addHash adds a new element by its digest while holding lock
+func (b *baseFilter) addHash(d Digest) {
 	for i := 0; i < b.k; i++ {
-		idx := getIndex(h1, h2, i, size) + (i * b.s)
-		// Increment counter up to 255
-		if b.bits[idx] < 0xFF {
-			b.bits[idx]++
-		}
+		b.bits.set(b.location(d, i))
 	}
 	b.n++
 }
 
 // Has checks if a element already exists in bit map
 func (b *baseFilter) Has(element []byte) bool {
-	h := b.createHash(element)
-	h1, h2 := divideHash(h)
-	size := len(b.bits)
-	// For partitioned filter
-	if b.s != 0 {
-		size = b.s
-	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.hasHash(b.createHash(element))
+}
+
+// HasHash checks if a element already exists in bit map by its digest.
+// Digest must be computed with the same hasher as filter.
+func (b *baseFilter) HasHash(d Digest) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.hasHash(d)
+}
+
+// hasHash checks a element by its digest while holding lock
+func (b *baseFilter) hasHash(d Digest) bool {
 	for i := 0; i < b.k; i++ {
-		idx := getIndex(h1, h2, i, size) + (i * b.s)
-		if b.bits[idx] == 0 {
+		if !b.bits.test(b.location(d, i)) {
 			return false
 		}
 	}
 	return true
 }
 
-func (b *baseFilter) toGobs() *baseGobs {
-	return &baseGobs{
-		Bits: b.bits,
-		K:    b.k,
-		N:    b.n,
-		S:    b.s,
+// Count gets the number of added elements
+func (b *baseFilter) Count() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return int64(b.n)
+}
+
+// checkCompatible checks if other has the same shape as filter
+func (b *baseFilter) checkCompatible(other *baseFilter) error {
+	switch {
+	case b.m != other.m:
+		return &IncompatibleError{Parameter: "m", Value: b.m, Other: other.m}
+	case b.k != other.k:
+		return &IncompatibleError{Parameter: "k", Value: b.k, Other: other.k}
+	case b.s != other.s:
+		return &IncompatibleError{Parameter: "s", Value: b.s, Other: other.s}
+	case b.hasherID() != other.hasherID():
+		return &IncompatibleError{Parameter: "hasher", Value: b.hasherID(), Other: other.hasherID()}
+	case keyCheck(b.hasher) != keyCheck(other.hasher):
+		return &IncompatibleError{Parameter: "key", Value: keyCheck(b.hasher), Other: keyCheck(other.hasher)}
+	case b.strategyID() != other.strategyID():
+		return &IncompatibleError{Parameter: "strategy", Value: b.strategyID(), Other: other.strategyID()}
 	}
+	return nil
+}
+
+// compatible checks if other has the same shape as filter while holding lock
+func (b *baseFilter) compatible(other *baseFilter) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.checkCompatible(other)
 }
 
-func (b *baseGobs) toFilter() *baseFilter {
+// snapshot copies filter while holding lock
+func (b *baseFilter) snapshot() *baseFilter {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return &baseFilter{
-		bits: b.Bits,
-		k:    b.K,
-		n:    b.N,
-		s:    b.S,
+		bits:     b.bits.clone(),
+		m:        b.m,
+		k:        b.k,
+		n:        b.n,
+		s:        b.s,
+		hasher:   b.hasher,
+		strategy: b.strategy,
+	}
+}
+
+// storageMismatch creates error for filters of different storage
+func storageMismatch(b, other *baseFilter) error {
+	return &IncompatibleError{
+		Parameter: "storage",
+		Value:     fmt.Sprintf("%T", b.bits),
+		Other:     fmt.Sprintf("%T", other.bits),
+	}
+}
+
+// combine calls f with a snapshot of other while holding lock of filter.
+// Locks of both filters are never held at once, so that filters combined with each other
+// at the same time never deadlock, and filter may be combined with itself.
+func (b *baseFilter) combine(other *baseFilter, f func(other *baseFilter) error) error {
+	return b.combineSnapshot(other.snapshot(), f)
+}
+
+// combineSnapshot calls f with other which nobody else refers to while holding lock of filter
+func (b *baseFilter) combineSnapshot(other *baseFilter, f func(other *baseFilter) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.checkCompatible(other)
+	if err != nil {
+		return err
 	}
+	return f(other)
+}
+
+// merge sets all bits of other into filter.
+// Counters of counting filter are added up, even when other is filter itself.
+func (b *baseFilter) merge(other *baseFilter) error {
+	return b.combine(other, b.mergeBits)
+}
+
+// mergeSnapshot sets all bits of other which nobody else refers to
+func (b *baseFilter) mergeSnapshot(other *baseFilter) error {
+	return b.combineSnapshot(other, b.mergeBits)
+}
+
+// mergeBits sets all bits of other while holding lock.
+// Elements of both may overlap, so the number of elements of bit map
+// is re-estimated from its fill ratio instead of summed up.
+func (b *baseFilter) mergeBits(other *baseFilter) error {
+	switch bits := b.bits.(type) {
+	case bitSet:
+		otherBits, ok := other.bits.(bitSet)
+		if !ok {
+			return storageMismatch(b, other)
+		}
+		bits.merge(otherBits)
+		b.n = int(b.estimateCount() + 0.5)
+	case counterSet:
+		otherBits, ok := other.bits.(counterSet)
+		if !ok {
+			return storageMismatch(b, other)
+		}
+		bits.merge(otherBits)
+		b.n += other.n
+	}
+	return nil
+}
+
+// intersect clears all bits which are not set in other
+func (b *baseFilter) intersect(other *baseFilter) error {
+	if b == other {
+		return nil
+	}
+	return b.combine(other, func(other *baseFilter) error {
+		bits, ok := b.bits.(bitSet)
+		otherBits, otherOK := other.bits.(bitSet)
+		if !ok || !otherOK {
+			return storageMismatch(b, other)
+		}
+		bits.intersect(otherBits)
+		b.n = int(b.estimateCount() + 0.5)
+		return nil
+	})
+}
+
+// load replaces state of filter with decoded one,
+// which takes the key of filter if it is keyed
+func (b *baseFilter) load(src *baseFilter) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.replace(src)
+}
+
+// replace replaces state of filter with decoded one while holding lock
+func (b *baseFilter) replace(src *baseFilter) error {
+	hasher, err := withKeyOf(src.hasher, b.hasher)
+	if err != nil {
+		return err
+	}
+	b.bits = src.bits
+	b.m = src.m
+	b.k = src.k
+	b.n = src.n
+	b.s = src.s
+	b.hasher = hasher
+	b.strategy = src.strategy
+	return nil
+}
+
+func (b *baseFilter) toGobs() *baseGobs {
+	bg := &baseGobs{
+		M:        b.m,
+		K:        b.k,
+		N:        b.n,
+		S:        b.s,
+		Hasher:   b.hasherID(),
+		KeyCheck: keyCheck(b.hasher),
+		Strategy: b.strategyID(),
+	}
+	switch bits := b.bits.(type) {
+	case bitSet:
+		bg.Words = bits
+	case counterSet:
+		bg.Bits = bits
+	}
+	return bg
+}
+
+// toFilter converts gobs to filter with packed bit map.
+// Streams having a byte per bit are migrated to packed layout.
+func (b *baseGobs) toFilter() (*baseFilter, error) {
+	hasher, err := lookupHasher(b.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := lookupIndexStrategy(b.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	bits := bitSet(b.Words)
+	m := b.M
+	if b.Words == nil {
+		m = len(b.Bits)
+		bits = newBitSet(m).fromBytes(b.Bits)
+	}
+	base := &baseFilter{
+		bits:     bits,
+		m:        m,
+		k:        b.K,
+		n:        b.N,
+		s:        b.S,
+		hasher:   withKeyCheck(hasher, b.KeyCheck),
+		strategy: strategy,
+	}
+	err = base.validate()
+	if err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// toCountingFilter converts gobs to filter with counters
+func (b *baseGobs) toCountingFilter() (*baseFilter, error) {
+	hasher, err := lookupHasher(b.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := lookupIndexStrategy(b.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	base := &baseFilter{
+		bits:     counterSet(b.Bits),
+		m:        len(b.Bits),
+		k:        b.K,
+		n:        b.N,
+		s:        b.S,
+		hasher:   withKeyCheck(hasher, b.KeyCheck),
+		strategy: strategy,
+	}
+	err = base.validate()
+	if err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// setBase sets decoded base filter to dst.
+// Base filter already in use is loaded in place under its lock.
+// Keyed filter is set only to dst keyed with the same key.
+func setBase(dst **baseFilter, src *baseFilter) error {
+	if *dst == nil {
+		_, err := withKeyOf(src.hasher, nil)
+		if err != nil {
+			return err
+		}
+		*dst = src
+		return nil
+	}
+	return (*dst).load(src)
 }
 
 // GobEncode encodes data to gobs stream
 func (b *baseFilter) GobEncode() ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	data := b.toGobs()
 	return gobEncode(data)
 }
@@ -140,19 +427,121 @@ type BloomFilter struct {
 	*baseFilter
 }
 
+// clampParams gets filter size of a slot at least
+// and number of hash functions within [1, filterSize],
+// so that every filter built is decoded from its own encoding
+func clampParams(filterSize, hasherNumber int) (int, int) {
+	if filterSize < 1 {
+		filterSize = 1
+	}
+	switch {
+	case hasherNumber < 1:
+		hasherNumber = 1
+	case hasherNumber > filterSize:
+		// Every partition must have a slot at least
+		hasherNumber = filterSize
+	}
+	return filterSize, hasherNumber
+}
+
 // New creates a new bloomfilter instance
-func New(filterSize, hasherNumber int) *BloomFilter {
+func New(filterSize, hasherNumber int, opts ...Option) *BloomFilter {
+	o := newOptions(opts...)
+	filterSize, hasherNumber = clampParams(filterSize, hasherNumber)
 	return &BloomFilter{
 		&baseFilter{
-			bits: make([]uint8, filterSize),
-			k:    hasherNumber,
+			bits:     newBitSet(filterSize),
+			m:        filterSize,
+			k:        hasherNumber,
+			hasher:   o.hasher,
+			strategy: o.strategy,
 		},
 	}
 }
 
+// getFalsePositiveIncidence computes the incidence of false positive
+// for k hash functions and n elements over m slots
+func getFalsePositiveIncidence(k, n, m int) float64 {
+	return math.Pow((1 - math.Exp(float64(-k*n)/float64(m))), float64(k))
+}
+
 // GetFalsePositiveIncidence gets the incidence of false positive
 func (b *BloomFilter) GetFalsePositiveIncidence() float64 {
-	return math.Pow((1 - math.Exp(float64(-b.k*b.n)/float64(len(b.bits)))), float64(b.k))
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return getFalsePositiveIncidence(b.k, b.n, b.m)
+}
+
+// Merge sets all elements of other bloomfilter into filter
+func (b *BloomFilter) Merge(other Filter) error {
+	o, ok := other.(*BloomFilter)
+	if !ok {
+		return typeMismatch(b, other)
+	}
+	return b.UnionInPlace(o)
+}
+
+// Union creates a new bloomfilter which has elements of both filters
+func (b *BloomFilter) Union(other *BloomFilter) (*BloomFilter, error) {
+	u := &BloomFilter{b.snapshot()}
+	err := u.UnionInPlace(other)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UnionInPlace sets all elements of other into filter.
+// The number of elements is re-estimated from the fill ratio.
+func (b *BloomFilter) UnionInPlace(other *BloomFilter) error {
+	return b.merge(other.baseFilter)
+}
+
+// Intersect creates a new bloomfilter which has elements common to both filters.
+// It may answer true for more elements than bloomfilter built from the intersection.
+func (b *BloomFilter) Intersect(other *BloomFilter) (*BloomFilter, error) {
+	i := &BloomFilter{b.snapshot()}
+	err := i.IntersectInPlace(other)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// IntersectInPlace drops elements of filter which other does not have.
+// The number of elements is re-estimated from the fill ratio.
+func (b *BloomFilter) IntersectInPlace(other *BloomFilter) error {
+	return b.intersect(other.baseFilter)
+}
+
+// Fold creates a new bloomfilter of size m/factor by ORing every bit i into bit i%(m/factor).
+// Its incidence of false positive is higher, but it never misses elements of filter.
+// Size must be a multiple of factor leaving at least k slots,
+// and index strategy must be modular, as built-in ones are.
+func (b *BloomFilter) Fold(factor int) (*BloomFilter, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if factor <= 0 || b.m%factor != 0 || b.m/factor < b.k {
+		return nil, ErrInvalidFoldFactor
+	}
+	strategy := b.strategy
+	if strategy == nil {
+		strategy = DefaultIndexStrategy
+	}
+	if _, ok := strategy.(modularStrategy); !ok {
+		return nil, ErrNotFoldable
+	}
+	size := b.m / factor
+	return &BloomFilter{
+		&baseFilter{
+			bits:     b.bits.(bitSet).fold(size),
+			m:        size,
+			k:        b.k,
+			n:        b.n,
+			hasher:   b.hasher,
+			strategy: b.strategy,
+		},
+	}, nil
 }
 
 // GobDecode decodes gob stream
@@ -163,6 +552,9 @@ func (b *BloomFilter) GobDecode(data []byte) error {
 		return err
 	}
 
-	b.baseFilter = bg.toFilter()
-	return nil
+	base, err := bg.toFilter()
+	if err != nil {
+		return err
+	}
+	return setBase(&b.baseFilter, base)
 }
@@ -1,6 +1,9 @@
 package blooms
 
 import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/satori/go.uuid"
@@ -17,7 +20,8 @@ func TestNew(t *testing.T) {
 
 			Convey("Then created instance should be expected", func() {
 				So(b, ShouldNotBeNil)
-				So(len(b.bits), ShouldEqual, m)
+				So(b.m, ShouldEqual, m)
+				So(len(b.bits.(bitSet)), ShouldEqual, 2)
 				So(b.k, ShouldEqual, k)
 				So(b.s, ShouldEqual, 0)
 
@@ -32,8 +36,10 @@ func TestBaseFilter_Add(t *testing.T) {
 		k := 5
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		Convey("When adding a new element", func() {
@@ -43,8 +49,8 @@ func TestBaseFilter_Add(t *testing.T) {
 			Convey("Then element should be added", func() {
 				So(b.n, ShouldEqual, 1)
 				var count int
-				for i := range b.bits {
-					if b.bits[i] == 1 {
+				for i := 0; i < b.m; i++ {
+					if b.bits.test(i) {
 						count++
 					}
 				}
@@ -59,8 +65,11 @@ func TestBaseFilter_Add(t *testing.T) {
 		k := 5
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			hasher:   FNV1aHasher,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		Convey("When adding a new element", func() {
@@ -70,8 +79,8 @@ func TestBaseFilter_Add(t *testing.T) {
 			Convey("Then element should be added", func() {
 				So(b.n, ShouldEqual, 1)
 				var count int
-				for i := range b.bits {
-					if b.bits[i] == 1 {
+				for i := 0; i < b.m; i++ {
+					if b.bits.test(i) {
 						count++
 					}
 				}
@@ -87,9 +96,11 @@ func TestBaseFilter_Add(t *testing.T) {
 		s := int(m / k)
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
-			s:    s,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			s:        s,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		Convey("When adding a new element", func() {
@@ -101,8 +112,8 @@ func TestBaseFilter_Add(t *testing.T) {
 				var count int
 				previous := 0
 				current := s
-				for i := range b.bits {
-					if b.bits[i] == 1 {
+				for i := 0; i < b.m; i++ {
+					if b.bits.test(i) {
 						So(i, ShouldBeBetweenOrEqual, previous, current)
 						previous = current
 						current += s
@@ -122,8 +133,10 @@ func TestBaseFilter_Has(t *testing.T) {
 		k := 5
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		e := []byte("test")
@@ -153,8 +166,11 @@ func TestBaseFilter_Has(t *testing.T) {
 		k := 5
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			hasher:   FNV1aHasher,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		e := []byte("test")
@@ -185,9 +201,11 @@ func TestBaseFilter_Has(t *testing.T) {
 		s := int(m / k)
 
 		b := &baseFilter{
-			bits: make([]uint8, m),
-			k:    k,
-			s:    s,
+			bits:     newBitSet(m),
+			m:        m,
+			k:        k,
+			s:        s,
+			strategy: EnhancedDoubleHashing,
 		}
 
 		e := []byte("test")
@@ -237,6 +255,91 @@ func TestBloomFilter_GetFalsePositiveIncidence(t *testing.T) {
 
 }
 
+// plainModulo is a strategy which is not known to be foldable
+type plainModulo struct{}
+
+func (plainModulo) ID() string { return "plain-modulo" }
+
+func (plainModulo) Index(h1, h2 uint64, i, size int) int {
+	return int((h1 + uint64(i)*h2) % uint64(size))
+}
+
+func TestBloomFilter_Fold(t *testing.T) {
+	Convey("Given bloom filters built with every strategy", t, func() {
+		m := 1 << 14
+		n := 1000
+		strategies := []IndexStrategy{legacyDoubleHashing{}, DoubleHashing, EnhancedDoubleHashing, TripleHashing, SeededHashing}
+
+		Convey("When folding them", func() {
+			for _, strategy := range strategies {
+				b := New(m, 5, WithIndexStrategy(strategy))
+				for i := 0; i < n; i++ {
+					b.Add([]byte(fmt.Sprint(i)))
+				}
+
+				for _, factor := range []int{2, 4} {
+					f, err := b.Fold(factor)
+
+					Convey(fmt.Sprintf("Then %s filter folded by %d should have every element", strategy.ID(), factor), func() {
+						So(err, ShouldBeNil)
+						So(f.m, ShouldEqual, m/factor)
+						So(f.Count(), ShouldEqual, n)
+						var missed int
+						for i := 0; i < n; i++ {
+							if !f.Has([]byte(fmt.Sprint(i))) {
+								missed++
+							}
+						}
+						So(missed, ShouldEqual, 0)
+
+					})
+
+					Convey(fmt.Sprintf("Then %s filter folded by %d should report its false positive", strategy.ID(), factor), func() {
+						expected := f.GetFalsePositiveIncidence()
+						So(expected, ShouldBeGreaterThan, b.GetFalsePositiveIncidence())
+						var count int
+						queries := 20000
+						for i := 0; i < queries; i++ {
+							if f.Has([]byte(fmt.Sprint("x", i))) {
+								count++
+							}
+						}
+						So(float64(count)/float64(queries), ShouldAlmostEqual, expected, expected/2+0.001)
+
+					})
+				}
+			}
+		})
+
+		Convey("When folding by a factor which does not divide size", func() {
+			_, err := New(m, 5).Fold(3)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrInvalidFoldFactor)
+
+			})
+		})
+
+		Convey("When folding to fewer slots than hash functions", func() {
+			_, err := New(m, 5).Fold(m / 4)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrInvalidFoldFactor)
+
+			})
+		})
+
+		Convey("When folding filter with unknown strategy", func() {
+			_, err := New(m, 5, WithIndexStrategy(plainModulo{})).Fold(2)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrNotFoldable)
+
+			})
+		})
+	})
+}
+
 func TestBaseFilter_GobEncode(t *testing.T) {
 	Convey("Given bloom filter", t, func() {
 		m := 128
@@ -277,7 +380,7 @@ func TestBloomFilter_GobDecode(t *testing.T) {
 
 			Convey("Then expected bytes slice should be returned", func() {
 				So(err, ShouldBeNil)
-				So(len(res.bits), ShouldEqual, 128)
+				So(res.m, ShouldEqual, 128)
 				So(res.k, ShouldEqual, b.k)
 				So(res.s, ShouldEqual, b.s)
 				So(res.Has([]byte("test")), ShouldBeTrue)
@@ -286,3 +389,88 @@ func TestBloomFilter_GobDecode(t *testing.T) {
 		})
 	})
 }
+
+// readLegacy reads gob stream in testdata encoded by the code before bit packing,
+// as listed in testdata/README.md
+func readLegacy(name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name+"-legacy.gob"))
+	So(err, ShouldBeNil)
+	return data
+}
+
+func TestBloomFilter_GobDecode_Legacy(t *testing.T) {
+	Convey("Given gob streams encoded with a byte per bit by the original code", t, func() {
+		abc := []string{"a", "b", "c"}
+
+		Convey("When decoding gobs stream as bloom filter", func() {
+			res := &BloomFilter{}
+			err := res.GobDecode(readLegacy("bloom"))
+
+			Convey("Then filter should be migrated to packed bit map", func() {
+				So(err, ShouldBeNil)
+				So(res.m, ShouldEqual, 128)
+				So(len(res.bits.(bitSet)), ShouldEqual, 2)
+				So(res.k, ShouldEqual, 3)
+				So(res.n, ShouldEqual, 3)
+				for _, e := range abc {
+					So(res.Has([]byte(e)), ShouldBeTrue)
+				}
+				So(res.Has([]byte("not_set")), ShouldBeFalse)
+
+			})
+		})
+
+		Convey("When decoding gobs stream as counting filter", func() {
+			res := &CountingFilter{}
+			err := res.GobDecode(readLegacy("counting"))
+
+			Convey("Then counters should be kept", func() {
+				So(err, ShouldBeNil)
+				So(res.m, ShouldEqual, 64)
+				So(res.n, ShouldEqual, 3)
+				So(res.Has([]byte("a")), ShouldBeTrue)
+				So(res.Has([]byte("b")), ShouldBeTrue)
+
+				res.Remove([]byte("b"))
+				So(res.Has([]byte("b")), ShouldBeTrue)
+				res.Remove([]byte("b"))
+				So(res.Has([]byte("b")), ShouldBeFalse)
+				So(res.Has([]byte("a")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding gobs stream as partitioned filter", func() {
+			res := &PartitionedFilter{}
+			err := res.GobDecode(readLegacy("partitioned"))
+
+			Convey("Then filter should be migrated to packed bit map", func() {
+				So(err, ShouldBeNil)
+				So(res.m, ShouldEqual, 128)
+				So(res.n, ShouldEqual, 3)
+				for _, e := range abc {
+					So(res.Has([]byte(e)), ShouldBeTrue)
+				}
+
+			})
+		})
+
+		Convey("When decoding gobs stream as scalable filter", func() {
+			res := &ScalableFilter{}
+			err := res.GobDecode(readLegacy("scalable"))
+
+			Convey("Then every filter should be migrated", func() {
+				So(err, ShouldBeNil)
+				So(len(res.filters), ShouldEqual, 2)
+				So(res.Count(), ShouldEqual, 20)
+				for i := 0; i < 20; i++ {
+					So(res.Has([]byte(fmt.Sprintf("element-%d", i))), ShouldBeTrue)
+				}
+
+				res.Add([]byte("added"))
+				So(res.Has([]byte("added")), ShouldBeTrue)
+
+			})
+		})
+	})
+}
@@ -0,0 +1,268 @@
+package blooms
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Encodings of bit map in binary format version 2
+const (
+	encodingRaw  = 0
+	encodingRice = 1
+)
+
+// maxRice is the bound of parameter of Rice code,
+// so that a gap of set bits within maxSlots never overflows int
+const maxRice = 62
+
+// maxInflatedSlots is the max number of slots of compressed bit map which can be decoded.
+// Compressed bit map is far smaller than the bit map it inflates to,
+// so it can be lowered to bound memory allocated for data not trusted.
+var maxInflatedSlots = maxSlots
+
+// riceCode is the size of Golomb-Rice codes of gaps between set bits
+type riceCode struct {
+	// Number of set bits
+	count int
+	// Parameter of Rice code
+	rice uint
+	// Number of bits of codes
+	bits int64
+}
+
+// bytes gets the number of bytes of codes
+func (c riceCode) bytes() int64 {
+	return (c.bits + 7) / 8
+}
+
+// eachSetBit calls f with every set bit in ascending order
+func (bs bitSet) eachSetBit(f func(i int)) {
+	for w, word := range bs {
+		for word != 0 {
+			f(w*wordSize + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+// riceCode gets the smallest Rice code of gaps between set bits.
+// Parameters around log2 of the mean gap are tried, which are optimal for geometric gaps.
+func (bs bitSet) riceCode() riceCode {
+	count := bs.count()
+	if count == 0 {
+		return riceCode{}
+	}
+	mean := float64(len(bs)*wordSize-count) / float64(count)
+	var r0 uint
+	if mean >= 2 {
+		r0 = uint(math.Log2(mean))
+	}
+	var candidates []uint
+	for r := r0; r <= r0+1 && r < maxRice; r++ {
+		candidates = append(candidates, r)
+	}
+	if r0 > 0 {
+		candidates = append(candidates, r0-1)
+	}
+
+	// Unary parts of every candidate are summed in a single pass
+	unary := make([]int64, len(candidates))
+	prev := -1
+	bs.eachSetBit(func(i int) {
+		gap := uint64(i - prev - 1)
+		for j, r := range candidates {
+			unary[j] += int64(gap >> r)
+		}
+		prev = i
+	})
+	best := riceCode{count: count, bits: math.MaxInt64}
+	for j, r := range candidates {
+		size := unary[j] + int64(count)*int64(r+1)
+		if size < best.bits {
+			best.rice = r
+			best.bits = size
+		}
+	}
+	return best
+}
+
+// bitWriter packs bits through a fixed size buffer from the least significant bit of each byte
+type bitWriter struct {
+	w     *binaryWriter
+	chunk [binaryChunkSize]byte
+	// Number of full bytes in chunk
+	n int
+	// Number of bits in the byte being packed
+	bit uint
+}
+
+func (bw *bitWriter) put(b bool) {
+	if b {
+		bw.chunk[bw.n] |= 1 << bw.bit
+	}
+	bw.bit++
+	if bw.bit < 8 {
+		return
+	}
+	bw.bit = 0
+	bw.n++
+	if bw.n == len(bw.chunk) {
+		bw.w.write(bw.chunk[:])
+		bw.chunk = [binaryChunkSize]byte{}
+		bw.n = 0
+	}
+}
+
+// flush writes packed bits with the last byte padded
+func (bw *bitWriter) flush() {
+	if bw.bit > 0 {
+		bw.n++
+	}
+	bw.w.write(bw.chunk[:bw.n])
+}
+
+// compressedWords writes bit map with Golomb-Rice coding when it is smaller than raw words
+func (w *binaryWriter) compressedWords(words bitSet) {
+	code := words.riceCode()
+	// Count, parameter and length precede codes
+	if 8+1+8+code.bytes() >= int64(len(words))*8 {
+		w.uint8(encodingRaw)
+		w.wordsWith(len(words), func(i int) uint64 {
+			return words[i]
+		})
+		return
+	}
+
+	w.uint8(encodingRice)
+	w.uint64(uint64(code.count))
+	w.uint8(uint8(code.rice))
+	w.uint64(uint64(code.bytes()))
+	bw := &bitWriter{w: w}
+	prev := -1
+	words.eachSetBit(func(i int) {
+		gap := uint64(i - prev - 1)
+		for q := gap >> code.rice; q > 0; q-- {
+			bw.put(true)
+		}
+		bw.put(false)
+		for j := uint(0); j < code.rice; j++ {
+			bw.put(gap&(1<<j) != 0)
+		}
+		prev = i
+	})
+	bw.flush()
+}
+
+// bitReader unpacks bits of length bytes through a fixed size buffer
+type bitReader struct {
+	r     *binaryReader
+	chunk [binaryChunkSize]byte
+	// Number of bytes not read yet
+	length uint64
+	// Number of bytes in chunk and index of the byte being unpacked
+	n, i int
+	// Number of bits unpacked from the byte
+	bit uint
+}
+
+// get unpacks a bit, and it fails if codes run out
+func (br *bitReader) get() bool {
+	if br.i == br.n {
+		if br.length == 0 {
+			br.r.fail(ErrInvalidFormat)
+			return false
+		}
+		br.n = len(br.chunk)
+		if uint64(br.n) > br.length {
+			br.n = int(br.length)
+		}
+		if !br.r.read(br.chunk[:br.n]) {
+			return false
+		}
+		br.length -= uint64(br.n)
+		br.i = 0
+	}
+	b := br.chunk[br.i]&(1<<br.bit) != 0
+	br.bit++
+	if br.bit == 8 {
+		br.bit = 0
+		br.i++
+	}
+	return b
+}
+
+// end checks if all codes are read and padding bits are zero
+func (br *bitReader) end() bool {
+	if br.bit > 0 {
+		if br.chunk[br.i]>>br.bit != 0 {
+			return false
+		}
+		br.i++
+	}
+	return br.i == br.n && br.length == 0
+}
+
+// compressedWords reads bit map of m bits preceded by its encoding
+func (r *binaryReader) compressedWords(m int) bitSet {
+	switch r.uint8() {
+	case encodingRaw:
+		return r.words(wordNumber(m))
+	case encodingRice:
+	default:
+		r.fail(ErrInvalidFormat)
+		return nil
+	}
+
+	count := r.uint64()
+	rice := uint(r.uint8())
+	length := r.uint64()
+	if r.err != nil {
+		return nil
+	}
+	if count > uint64(m) || rice >= maxRice {
+		r.fail(ErrInvalidFormat)
+		return nil
+	}
+	if m > maxInflatedSlots {
+		r.fail(invalid("M", "must be within [1, %d] to inflate, but %d", maxInflatedSlots, m))
+		return nil
+	}
+
+	words := newBitSet(m)
+	br := &bitReader{r: r, length: length}
+	prev := -1
+	for c := uint64(0); c < count; c++ {
+		var q uint64
+		for br.get() {
+			q++
+			// Gap must stay within bit map
+			if q > uint64(m)>>rice {
+				r.fail(ErrInvalidFormat)
+				return nil
+			}
+		}
+		var low uint64
+		for j := uint(0); j < rice; j++ {
+			if br.get() {
+				low |= 1 << j
+			}
+		}
+		if r.err != nil {
+			return nil
+		}
+		i := prev + 1 + int(q<<rice|low)
+		if i >= m {
+			r.fail(ErrInvalidFormat)
+			return nil
+		}
+		words.set(i)
+		prev = i
+	}
+	if r.err == nil && !br.end() {
+		r.fail(ErrInvalidFormat)
+	}
+	if r.err != nil {
+		return nil
+	}
+	return words
+}
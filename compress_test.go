@@ -0,0 +1,135 @@
+package blooms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarshalCompressed(t *testing.T) {
+	Convey("Given filters of golden vectors", t, func() {
+		for _, v := range goldenVectors {
+			f := v.filter()
+			for _, e := range v.elements {
+				f.Add([]byte(e))
+			}
+
+			Convey(fmt.Sprintf("When compressing %s filter", v.name), func() {
+				data, err := f.MarshalCompressed()
+				So(err, ShouldBeNil)
+				d := v.filter()
+				err = d.UnmarshalBinary(data)
+
+				Convey("Then it should round trip losslessly", func() {
+					So(err, ShouldBeNil)
+					So(d.(Estimator).Count(), ShouldEqual, len(v.elements))
+					raw, err := f.MarshalBinary()
+					So(err, ShouldBeNil)
+					decoded, err := d.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(bytes.Equal(decoded, raw), ShouldBeTrue)
+
+				})
+			})
+		}
+	})
+
+	Convey("Given sparse filter", t, func() {
+		b := New(1<<20, 3)
+		for _, e := range elementsOf(1000) {
+			b.Add([]byte(e))
+		}
+
+		Convey("When compressing it", func() {
+			raw, err := b.MarshalBinary()
+			So(err, ShouldBeNil)
+			data, err := b.MarshalCompressed()
+			So(err, ShouldBeNil)
+			var d BloomFilter
+			err = d.UnmarshalBinary(data)
+
+			Convey("Then it should be coded with Golomb-Rice coding far smaller than raw", func() {
+				So(data[4], ShouldEqual, binaryVersionCompressed)
+				So(data[6+8+4+8+8+8+1+len("murmur3-64")+8+1+len("enhanced-double")], ShouldEqual, encodingRice)
+				So(len(data), ShouldBeLessThan, len(raw)/10)
+				So(err, ShouldBeNil)
+				for _, e := range elementsOf(1000) {
+					So(d.Has([]byte(e)), ShouldBeTrue)
+				}
+
+			})
+		})
+	})
+
+	Convey("Given dense filter", t, func() {
+		b := New(1024, 3)
+		for _, e := range elementsOf(500) {
+			b.Add([]byte(e))
+		}
+
+		Convey("When compressing it", func() {
+			raw, err := b.MarshalBinary()
+			So(err, ShouldBeNil)
+			data, err := b.MarshalCompressed()
+			So(err, ShouldBeNil)
+
+			Convey("Then raw words should be taken with a byte of encoding", func() {
+				So(len(data), ShouldEqual, len(raw)+1)
+
+			})
+		})
+	})
+}
+
+func TestUnmarshalBinary_Compressed(t *testing.T) {
+	Convey("Given compressed sparse filter", t, func() {
+		b := New(4096, 3)
+		b.Add([]byte("a"))
+		data, err := b.MarshalCompressed()
+		So(err, ShouldBeNil)
+		// Offset of count of set bits after header and parameters of base
+		count := 6 + 8 + 4 + 8 + 8 + 8 + 1 + len("murmur3-64") + 8 + 1 + len("enhanced-double") + 1
+		forge := func(f func(data []byte)) []byte {
+			forged := append([]byte(nil), data...)
+			f(forged)
+			return withChecksum(forged)
+		}
+
+		Convey("When decoding forged codes", func() {
+			cases := []struct {
+				name string
+				data []byte
+			}{
+				{"encoding", forge(func(d []byte) { d[count-1] = 2 })},
+				{"more set bits", forge(func(d []byte) { binary.LittleEndian.PutUint64(d[count:], 4) })},
+				{"fewer set bits", forge(func(d []byte) { binary.LittleEndian.PutUint64(d[count:], 2) })},
+				{"parameter", forge(func(d []byte) { d[count+8] = maxRice })},
+				{"gap over bit map", forge(func(d []byte) { binary.LittleEndian.PutUint64(d[6:], 64) })},
+			}
+			for _, c := range cases {
+				var d BloomFilter
+				err := d.UnmarshalBinary(c.data)
+
+				Convey(fmt.Sprintf("Then error should be returned for %s", c.name), func() {
+					So(err, ShouldNotBeNil)
+
+				})
+			}
+		})
+
+		Convey("When decoding it beyond max inflated size", func() {
+			defer func(max int) { maxInflatedSlots = max }(maxInflatedSlots)
+			maxInflatedSlots = 1024
+			var d BloomFilter
+			err := d.UnmarshalBinary(data)
+
+			Convey("Then error should be returned before allocating", func() {
+				So(err.(*InvalidFilterError).Field, ShouldEqual, "M")
+
+			})
+		})
+	})
+}
@@ -0,0 +1,226 @@
+package blooms
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// runConcurrently runs every operation in its own goroutines and waits for them
+func runConcurrently(workers int, ops ...func(w, i int)) {
+	var wg sync.WaitGroup
+	for _, op := range ops {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(op func(w, i int), w int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					op(w, i)
+				}
+			}(op, w)
+		}
+	}
+	wg.Wait()
+}
+
+func TestConcurrency(t *testing.T) {
+	Convey("Given every filter type", t, func() {
+		workers := 4
+		elm := func(w, i int) []byte {
+			return []byte{byte(w), byte(i)}
+		}
+
+		Convey("When adding, checking and encoding bloom filter concurrently", func() {
+			b := New(1<<12, 5)
+			other := New(1<<12, 5)
+			runConcurrently(workers,
+				func(w, i int) { b.Add(elm(w, i)) },
+				func(w, i int) { b.Has(elm(w, i)) },
+				func(w, i int) { other.Add(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						b.GobEncode()
+						b.WriteTo(ioutil.Discard)
+						b.GetFalsePositiveIncidence()
+						b.Count()
+						b.Merge(other)
+						other.Merge(b)
+					}
+				},
+			)
+
+			Convey("Then every element should exist", func() {
+				So(b.Has(elm(0, 0)), ShouldBeTrue)
+				So(b.Has(elm(workers-1, 199)), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When adding, removing, checking and encoding counting filter concurrently", func() {
+			c := NewCountingFilter(1<<12, 5)
+			runConcurrently(workers,
+				func(w, i int) {
+					c.Add(elm(w, i))
+					c.Add(append(elm(w, i), 'r'))
+				},
+				func(w, i int) { c.Remove(append(elm(w, i), 'r')) },
+				func(w, i int) { c.Has(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						c.GobEncode()
+						c.WriteTo(ioutil.Discard)
+						c.GetFalsePositiveIncidence()
+						c.Count()
+					}
+				},
+			)
+
+			Convey("Then every element not removed should exist", func() {
+				var missing int
+				for w := 0; w < workers; w++ {
+					for i := 0; i < 200; i++ {
+						if !c.Has(elm(w, i)) {
+							missing++
+						}
+					}
+				}
+				So(missing, ShouldEqual, 0)
+
+			})
+		})
+
+		Convey("When adding, checking and encoding partitioned filter concurrently", func() {
+			p := NewPartitionedFilter(1<<12, 5)
+			runConcurrently(workers,
+				func(w, i int) { p.Add(elm(w, i)) },
+				func(w, i int) { p.HasHash(HashOf(elm(w, i))) },
+				func(w, i int) {
+					if i%50 == 0 {
+						p.GobEncode()
+						p.WriteTo(ioutil.Discard)
+						p.GetFalsePositiveIncidence()
+						p.Count()
+					}
+				},
+			)
+
+			Convey("Then every element should exist", func() {
+				So(p.Count(), ShouldEqual, workers*200)
+
+			})
+		})
+
+		Convey("When decoding into bloom filter in use", func() {
+			b := New(1<<12, 5)
+			src := New(1<<12, 5)
+			src.Add([]byte("decoded"))
+			buf, _ := src.GobEncode()
+			runConcurrently(workers,
+				func(w, i int) { b.Has(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						b.GobDecode(buf)
+					}
+				},
+			)
+
+			Convey("Then decoded state should be loaded", func() {
+				So(b.Has([]byte("decoded")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding into partitioned filter in use", func() {
+			p := NewPartitionedFilter(1<<12, 5)
+			src := NewPartitionedFilter(1<<10, 3)
+			src.Add([]byte("decoded"))
+			buf, _ := src.GobEncode()
+			data, _ := src.MarshalBinary()
+			j, _ := src.MarshalJSON()
+			runConcurrently(workers,
+				func(w, i int) { p.Has(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						p.GobEncode()
+						p.WriteTo(ioutil.Discard)
+						p.MarshalJSON()
+					}
+				},
+				func(w, i int) {
+					if i%50 == 0 {
+						p.GobDecode(buf)
+						p.UnmarshalBinary(data)
+						p.UnmarshalJSON(j)
+					}
+				},
+			)
+
+			Convey("Then decoded state should be loaded", func() {
+				So(p.Has([]byte("decoded")), ShouldBeTrue)
+				So(p.maxN, ShouldEqual, src.maxN)
+
+			})
+		})
+
+		Convey("When decoding into sharded filter in use", func() {
+			sf := NewShardedCountingFilter(4, 1<<10, 5)
+			other := NewShardedCountingFilter(4, 1<<10, 5)
+			src := NewShardedCountingFilter(4, 1<<10, 5)
+			src.Add([]byte("decoded"))
+			buf, _ := src.GobEncode()
+			data, _ := src.MarshalBinary()
+			j, _ := src.MarshalJSON()
+			runConcurrently(workers,
+				func(w, i int) { sf.Add(elm(w, i)) },
+				func(w, i int) { sf.Has(elm(w, i)) },
+				func(w, i int) { sf.Remove(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						sf.GobDecode(buf)
+						sf.UnmarshalBinary(data)
+						sf.UnmarshalJSON(j)
+						sf.Count()
+						sf.Merge(other)
+						other.Merge(sf)
+					}
+				},
+			)
+
+			Convey("Then decoded state should be loaded", func() {
+				So(sf.GobDecode(buf), ShouldBeNil)
+				So(sf.Has([]byte("decoded")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding into scalable filter in use", func() {
+			sf := NewScalableFilter(1<<8, 2, 0.01, 0.8)
+			src := NewScalableFilter(1<<8, 2, 0.01, 0.8)
+			src.Add([]byte("decoded"))
+			buf, _ := src.GobEncode()
+			data, _ := src.MarshalBinary()
+			j, _ := src.MarshalJSON()
+			runConcurrently(workers,
+				func(w, i int) { sf.Add(elm(w, i)) },
+				func(w, i int) { sf.Has(elm(w, i)) },
+				func(w, i int) {
+					if i%50 == 0 {
+						sf.GobDecode(buf)
+						sf.UnmarshalBinary(data)
+						sf.UnmarshalJSON(j)
+						sf.Count()
+						sf.GetFalsePositiveIncidence()
+					}
+				},
+			)
+
+			Convey("Then decoded state should be loaded", func() {
+				So(sf.GobDecode(buf), ShouldBeNil)
+				So(sf.Has([]byte("decoded")), ShouldBeTrue)
+
+			})
+		})
+	})
+}
@@ -8,26 +8,84 @@ type CountingFilter struct {
 }
 
 // NewCountingFilter creates a new cuntable bloomfilter instance
-func NewCountingFilter(filterSize, hasherNumber int) *CountingFilter {
+func NewCountingFilter(filterSize, hasherNumber int, opts ...Option) *CountingFilter {
+	o := newOptions(opts...)
+	filterSize, hasherNumber = clampParams(filterSize, hasherNumber)
 	return &CountingFilter{
 		&baseFilter{
-			bits: make([]uint8, filterSize),
-			k:    hasherNumber,
+			bits:     newCounterSet(filterSize),
+			m:        filterSize,
+			k:        hasherNumber,
+			hasher:   o.hasher,
+			strategy: o.strategy,
 		},
 	}
 }
 
 // Remove removes a element from counting filter
 func (c *CountingFilter) Remove(element []byte) {
-	h := c.createHash(element)
-	h1, h2 := divideHash(h)
-	for i := 0; i < c.k; i++ {
-		idx := getIndex(h1, h2, i, len(c.bits))
-		if c.bits[idx] > 0 {
-			c.bits[idx]--
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeHash(c.createHash(element))
+}
+
+// RemoveHash removes a element from counting filter by its digest.
+// Digest must be computed with the same hasher as filter.
+func (c *CountingFilter) RemoveHash(d Digest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeHash(d)
+}
+
+// removeHash removes a element by its digest while holding lock
+func (b *baseFilter) removeHash(d Digest) {
+	counters := b.bits.(counterSet)
+	for i := 0; i < b.k; i++ {
+		counters.unset(b.location(d, i))
+	}
+	if b.n > 0 {
+		b.n--
+	}
+}
+
+// subtract removes all elements of other from filter
+func (b *baseFilter) subtract(other *baseFilter) error {
+	return b.combine(other, func(other *baseFilter) error {
+		counters, ok := b.bits.(counterSet)
+		otherCounters, otherOK := other.bits.(counterSet)
+		if !ok || !otherOK {
+			return storageMismatch(b, other)
+		}
+		counters.subtract(otherCounters)
+		b.n -= other.n
+		if b.n < 0 {
+			b.n = 0
 		}
+		return nil
+	})
+}
+
+// Merge adds counters of other counting filter to filter up to 255.
+// Merging filter into itself doubles its counters.
+func (c *CountingFilter) Merge(other Filter) error {
+	o, ok := other.(*CountingFilter)
+	if !ok {
+		return typeMismatch(c, other)
 	}
-	c.n--
+	return c.merge(o.baseFilter)
+}
+
+// Subtract removes all elements of other counting filter from filter at once.
+// Other must hold only elements added to filter, as Remove requires.
+func (c *CountingFilter) Subtract(other *CountingFilter) error {
+	return c.subtract(other.baseFilter)
+}
+
+// GetFalsePositiveIncidence gets the incidence of false positive
+func (c *CountingFilter) GetFalsePositiveIncidence() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return getFalsePositiveIncidence(c.k, c.n, c.m)
 }
 
 // GobDecode decodes gob stream
@@ -38,6 +96,9 @@ func (c *CountingFilter) GobDecode(data []byte) error {
 		return err
 	}
 
-	c.baseFilter = bg.toFilter()
-	return nil
+	base, err := bg.toCountingFilter()
+	if err != nil {
+		return err
+	}
+	return setBase(&c.baseFilter, base)
 }
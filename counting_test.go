@@ -1,6 +1,7 @@
 package blooms
 
 import (
+	"errors"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -16,7 +17,8 @@ func TestNewCountingFilter(t *testing.T) {
 
 			Convey("Then created instance should be expected", func() {
 				So(b, ShouldNotBeNil)
-				So(len(b.bits), ShouldEqual, m)
+				So(b.m, ShouldEqual, m)
+				So(len(b.bits.(counterSet)), ShouldEqual, m)
 				So(b.k, ShouldEqual, k)
 				So(b.s, ShouldEqual, 0)
 
@@ -39,8 +41,8 @@ func TestCountingFilter_Add(t *testing.T) {
 			Convey("Then element should be added", func() {
 				So(b.n, ShouldEqual, 1)
 				var count int
-				for i := range b.bits {
-					if b.bits[i] == 1 {
+				for _, c := range b.bits.(counterSet) {
+					if c == 1 {
 						count++
 					}
 				}
@@ -68,8 +70,8 @@ func TestCountingFilter_Remove(t *testing.T) {
 			Convey("Then element should remain", func() {
 				So(b.n, ShouldEqual, 0)
 				var count int
-				for i := range b.bits {
-					if b.bits[i] == 1 {
+				for _, c := range b.bits.(counterSet) {
+					if c == 1 {
 						count++
 					}
 				}
@@ -87,8 +89,8 @@ func TestCountingFilter_Remove(t *testing.T) {
 			Convey("Then element should be removed", func() {
 				So(b.n, ShouldEqual, 2)
 				var count int
-				for i := range b.bits {
-					if b.bits[i] != 0 {
+				for _, c := range b.bits.(counterSet) {
+					if c != 0 {
 						count++
 					}
 				}
@@ -100,6 +102,100 @@ func TestCountingFilter_Remove(t *testing.T) {
 	})
 }
 
+func TestCountingFilter_Merge(t *testing.T) {
+	Convey("Given counting filters of two nodes", t, func() {
+		a := NewCountingFilter(1024, 5)
+		b := NewCountingFilter(1024, 5)
+		a.Add([]byte("a"))
+		a.Add([]byte("both"))
+		b.Add([]byte("b"))
+		b.Add([]byte("both"))
+
+		Convey("When merging one into the other", func() {
+			err := a.Merge(b)
+
+			Convey("Then counters should be added up", func() {
+				So(err, ShouldBeNil)
+				So(a.Count(), ShouldEqual, 4)
+				So(a.Has([]byte("b")), ShouldBeTrue)
+
+			})
+
+			Convey("Then removing an element once should leave the other copy", func() {
+				a.Remove([]byte("both"))
+				So(a.Has([]byte("both")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging filter into itself", func() {
+			err := a.Merge(a)
+
+			Convey("Then every counter should be doubled", func() {
+				So(err, ShouldBeNil)
+				So(a.Count(), ShouldEqual, 4)
+				a.Remove([]byte("a"))
+				So(a.Has([]byte("a")), ShouldBeTrue)
+				a.Remove([]byte("a"))
+				So(a.Has([]byte("a")), ShouldBeFalse)
+
+			})
+		})
+
+		Convey("When merging another filter type", func() {
+			err := a.Merge(New(1024, 5))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestCountingFilter_Subtract(t *testing.T) {
+	Convey("Given counting filter and a batch added to it", t, func() {
+		c := NewCountingFilter(1024, 5)
+		batch := NewCountingFilter(1024, 5)
+		for i := 0; i < 10; i++ {
+			c.Add([]byte{byte(i)})
+		}
+		for i := 5; i < 10; i++ {
+			batch.Add([]byte{byte(i)})
+		}
+
+		Convey("When subtracting the batch", func() {
+			err := c.Subtract(batch)
+
+			Convey("Then only elements out of the batch should remain", func() {
+				So(err, ShouldBeNil)
+				So(c.Count(), ShouldEqual, 5)
+				for i := 0; i < 5; i++ {
+					So(c.Has([]byte{byte(i)}), ShouldBeTrue)
+				}
+				var removed int
+				for i := 5; i < 10; i++ {
+					if !c.Has([]byte{byte(i)}) {
+						removed++
+					}
+				}
+				So(removed, ShouldBeGreaterThanOrEqualTo, 4)
+
+			})
+		})
+
+		Convey("When subtracting filter with different shape", func() {
+			err := c.Subtract(NewCountingFilter(1024, 3))
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "k", Value: 5, Other: 3})
+				So(c.Count(), ShouldEqual, 10)
+
+			})
+		})
+	})
+}
+
 func TestCountingFilter_GobDecode(t *testing.T) {
 	Convey("Given bloom filter converted to gobs stream", t, func() {
 		m := 128
@@ -118,7 +214,8 @@ func TestCountingFilter_GobDecode(t *testing.T) {
 
 			Convey("Then expected bytes slice should be returned", func() {
 				So(err, ShouldBeNil)
-				So(len(res.bits), ShouldEqual, 128)
+				So(res.m, ShouldEqual, 128)
+				So(len(res.bits.(counterSet)), ShouldEqual, 128)
 				So(res.k, ShouldEqual, b.k)
 				So(res.s, ShouldEqual, b.s)
 				So(res.Has([]byte("test")), ShouldBeTrue)
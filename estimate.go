@@ -0,0 +1,158 @@
+package blooms
+
+import "math"
+
+// z95 is the standard normal quantile for 95% confidence
+const z95 = 1.959964
+
+// Estimate is estimated number of elements with its 95% confidence interval
+type Estimate struct {
+	// Most likely number of elements
+	Value float64
+	// Lower bound of confidence interval
+	Lower float64
+	// Upper bound of confidence interval.
+	// It is +Inf when bit map may be saturated.
+	Upper float64
+}
+
+// estimateBits estimates the number of elements which set x bits of size
+// with k bits per element by Swamidass and Baldi, -size/k*ln(1-x/size).
+// The interval maps x plus or minus z95 standard deviations of set bits
+// through the same estimator.
+func estimateBits(x, size, k float64) Estimate {
+	n := func(x float64) float64 {
+		return -size / k * math.Log(1-x/size)
+	}
+	// Saturated bit map is taken as lacking half a bit to keep estimate finite
+	value := n(math.Min(x, size-0.5))
+
+	// Variance of occupied slots after throwing k*value balls into size slots
+	q := math.Exp(-k * value / size)
+	sd := math.Sqrt(math.Max(size*q*(1-(1+k*value/size)*q), 0))
+
+	e := Estimate{
+		Value: value,
+		Lower: n(math.Max(x-z95*sd, 0)),
+		Upper: math.Inf(1),
+	}
+	if x+z95*sd < size {
+		e.Upper = n(x + z95*sd)
+	}
+	return e
+}
+
+// estimate estimates the number of distinct elements from set bits while holding lock
+func (b *baseFilter) estimate() Estimate {
+	bits, ok := b.bits.(bitSet)
+	if !ok || b.k == 0 {
+		n := float64(b.n)
+		return Estimate{Value: n, Lower: n, Upper: n}
+	}
+	return estimateBits(float64(bits.count()), b.estimateSize(), float64(b.k))
+}
+
+// estimateSize gets the number of bits which elements can set.
+// Partitioned filter sets a bit per partition, so it is k*s.
+func (b *baseFilter) estimateSize() float64 {
+	if b.s != 0 {
+		return float64(b.s * b.k)
+	}
+	return float64(b.m)
+}
+
+// estimateCount gets the most likely number of distinct elements while holding lock
+func (b *baseFilter) estimateCount() float64 {
+	return b.estimate().Value
+}
+
+// EstimateCount estimates the number of distinct elements from set bits.
+// Unlike Count, duplicates are not counted and it is valid after merge.
+func (b *BloomFilter) EstimateCount() Estimate {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.estimate()
+}
+
+// EstimateCount estimates the number of distinct elements from set bits
+func (p *PartitionedFilter) EstimateCount() Estimate {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.estimate()
+}
+
+// EstimateCount estimates the number of distinct elements in all filters.
+// An element added to several filters through merge is counted for each.
+func (sf *ScalableFilter) EstimateCount() Estimate {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	var e Estimate
+	var lower, upper float64
+	for _, pf := range sf.filters {
+		pe := pf.EstimateCount()
+		e.Value += pe.Value
+		// Filters are independent, so deviations are added up in quadrature
+		lower += (pe.Value - pe.Lower) * (pe.Value - pe.Lower)
+		upper += (pe.Upper - pe.Value) * (pe.Upper - pe.Value)
+	}
+	e.Lower = math.Max(e.Value-math.Sqrt(lower), 0)
+	e.Upper = e.Value + math.Sqrt(upper)
+	return e
+}
+
+// basesOf gets base filters of two bloom filters or two partitioned filters
+func basesOf(a, b Filter) (*baseFilter, *baseFilter, error) {
+	switch fa := a.(type) {
+	case *BloomFilter:
+		if fb, ok := b.(*BloomFilter); ok {
+			return fa.baseFilter, fb.baseFilter, nil
+		}
+	case *PartitionedFilter:
+		if fb, ok := b.(*PartitionedFilter); ok {
+			return fa.baseFilter, fb.baseFilter, nil
+		}
+	}
+	return nil, nil, typeMismatch(a, b)
+}
+
+// union takes snapshots of two compatible filters and their union
+func union(a, b Filter) (u, sa, sb *baseFilter, err error) {
+	ba, bb, err := basesOf(a, b)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sa, sb = ba.snapshot(), bb.snapshot()
+	u = sa.snapshot()
+	err = u.mergeSnapshot(sb)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return u, sa, sb, nil
+}
+
+// EstimateUnionCount estimates the number of distinct elements in either of
+// two compatible bloom filters or partitioned filters
+func EstimateUnionCount(a, b Filter) (Estimate, error) {
+	u, _, _, err := union(a, b)
+	if err != nil {
+		return Estimate{}, err
+	}
+	return u.estimate(), nil
+}
+
+// EstimateIntersectionCount estimates the number of distinct elements in both of
+// two compatible bloom filters or partitioned filters as |A|+|B|-|A∪B|.
+// The interval is conservative, because the three estimates are correlated.
+func EstimateIntersectionCount(a, b Filter) (Estimate, error) {
+	u, sa, sb, err := union(a, b)
+	if err != nil {
+		return Estimate{}, err
+	}
+	ea, eb, eu := sa.estimate(), sb.estimate(), u.estimate()
+	bound := math.Min(ea.Upper, eb.Upper)
+	return Estimate{
+		Value: math.Min(math.Max(ea.Value+eb.Value-eu.Value, 0), math.Min(ea.Value, eb.Value)),
+		Lower: math.Max(ea.Lower+eb.Lower-eu.Upper, 0),
+		Upper: math.Min(ea.Upper+eb.Upper-eu.Lower, bound),
+	}, nil
+}
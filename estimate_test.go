@@ -0,0 +1,180 @@
+package blooms
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEstimateBits(t *testing.T) {
+	Convey("Given bits set by elements", t, func() {
+
+		Convey("When no bit is set", func() {
+			e := estimateBits(0, 1024, 3)
+
+			Convey("Then estimate should be zero", func() {
+				So(e, ShouldResemble, Estimate{})
+
+			})
+		})
+
+		Convey("When every bit is set", func() {
+			e := estimateBits(1024, 1024, 3)
+
+			Convey("Then estimate should be finite with unbounded interval", func() {
+				So(math.IsInf(e.Value, 0), ShouldBeFalse)
+				So(e.Lower, ShouldBeLessThanOrEqualTo, e.Value)
+				So(math.IsInf(e.Upper, 1), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestBloomFilter_EstimateCount(t *testing.T) {
+	Convey("Given bloom filter with duplicated elements", t, func() {
+		b := New(1<<14, 5)
+		for i := 0; i < 1000; i++ {
+			b.Add([]byte(fmt.Sprint(i)))
+			b.Add([]byte(fmt.Sprint(i)))
+		}
+
+		Convey("When estimating the number of elements", func() {
+			e := b.EstimateCount()
+
+			Convey("Then distinct elements should be estimated", func() {
+				So(b.Count(), ShouldEqual, 2000)
+				So(e.Value, ShouldAlmostEqual, 1000, 50)
+				So(e.Lower, ShouldBeLessThan, 1000)
+				So(e.Upper, ShouldBeGreaterThan, 1000)
+
+			})
+		})
+	})
+
+	Convey("Given many bloom filters of the same number of elements", t, func() {
+		trials := 200
+		n := 500
+
+		Convey("When estimating their number of elements", func() {
+			var covered int
+			for i := 0; i < trials; i++ {
+				b := New(4096, 4)
+				for j := 0; j < n; j++ {
+					b.Add([]byte(fmt.Sprint(i, "-", j)))
+				}
+				e := b.EstimateCount()
+				if e.Lower <= float64(n) && float64(n) <= e.Upper {
+					covered++
+				}
+			}
+
+			Convey("Then about 95% of intervals should cover it", func() {
+				So(float64(covered)/float64(trials), ShouldBeGreaterThan, 0.9)
+
+			})
+		})
+	})
+}
+
+func TestPartitionedFilter_EstimateCount(t *testing.T) {
+	Convey("Given partitioned filter with duplicated elements", t, func() {
+		p := NewPartitionedFilter(1<<14, 5)
+		for i := 0; i < 1000; i++ {
+			p.Add([]byte(fmt.Sprint(i)))
+			p.Add([]byte(fmt.Sprint(i)))
+		}
+
+		Convey("When estimating the number of elements", func() {
+			e := p.EstimateCount()
+
+			Convey("Then distinct elements should be estimated", func() {
+				So(e.Value, ShouldAlmostEqual, 1000, 50)
+				So(e.Lower, ShouldBeLessThan, 1000)
+				So(e.Upper, ShouldBeGreaterThan, 1000)
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_EstimateCount(t *testing.T) {
+	Convey("Given scalable filter grown to several filters", t, func() {
+		sf := NewScalableFilter(1024, 2, 0.01, 0.8)
+		for i := 0; i < 5000; i++ {
+			sf.Add([]byte(fmt.Sprint(i)))
+		}
+
+		Convey("When estimating the number of elements", func() {
+			e := sf.EstimateCount()
+
+			Convey("Then it should be sum over filters", func() {
+				So(len(sf.filters), ShouldBeGreaterThan, 1)
+				So(e.Value, ShouldAlmostEqual, 5000, 250)
+				So(e.Lower, ShouldBeLessThan, e.Value)
+				So(e.Upper, ShouldBeGreaterThan, e.Value)
+
+			})
+		})
+	})
+}
+
+func TestEstimateUnionCount(t *testing.T) {
+	Convey("Given two bloom filters sharing a part of elements", t, func() {
+		a := New(1<<14, 5)
+		b := New(1<<14, 5)
+		for i := 0; i < 1000; i++ {
+			a.Add([]byte(fmt.Sprint(i)))
+			b.Add([]byte(fmt.Sprint(i + 700)))
+		}
+
+		Convey("When estimating union and intersection", func() {
+			u, err := EstimateUnionCount(a, b)
+			So(err, ShouldBeNil)
+			i, err := EstimateIntersectionCount(a, b)
+			So(err, ShouldBeNil)
+
+			Convey("Then both should be close to exact ones", func() {
+				So(u.Value, ShouldAlmostEqual, 1700, 85)
+				So(u.Lower, ShouldBeLessThan, 1700)
+				So(u.Upper, ShouldBeGreaterThan, 1700)
+				So(i.Value, ShouldAlmostEqual, 300, 60)
+				So(i.Lower, ShouldBeLessThan, 300)
+				So(i.Upper, ShouldBeGreaterThan, 300)
+
+			})
+
+			Convey("Then operands should not change", func() {
+				So(a.Count(), ShouldEqual, 1000)
+				So(b.Count(), ShouldEqual, 1000)
+
+			})
+		})
+
+		Convey("When estimating with filters of different types", func() {
+			_, err := EstimateUnionCount(a, NewPartitionedFilter(1<<14, 5))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+
+	Convey("Given two partitioned filters with different shape", t, func() {
+		a := NewPartitionedFilter(1<<14, 5)
+		b := NewPartitionedFilter(1<<14, 4)
+
+		Convey("When estimating intersection", func() {
+			_, err := EstimateIntersectionCount(a, b)
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "k", Value: 5, Other: 4})
+
+			})
+		})
+	})
+}
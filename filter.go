@@ -0,0 +1,135 @@
+package blooms
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrIncompatibleFilter is returned when filters with different shape are combined
+var ErrIncompatibleFilter = errors.New("blooms: incompatible filter")
+
+// IncompatibleError describes which parameter differs between combined filters.
+// It matches ErrIncompatibleFilter with errors.Is.
+type IncompatibleError struct {
+	// Name of differing parameter
+	Parameter string
+	// Value of filter
+	Value interface{}
+	// Value of other filter
+	Other interface{}
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("%s: %s %v != %v", ErrIncompatibleFilter, e.Parameter, e.Value, e.Other)
+}
+
+// Is reports whether target is ErrIncompatibleFilter
+func (e *IncompatibleError) Is(target error) bool {
+	return target == ErrIncompatibleFilter
+}
+
+// typeMismatch creates error for filters of different types
+func typeMismatch(filter, other interface{}) error {
+	return &IncompatibleError{
+		Parameter: "type",
+		Value:     fmt.Sprintf("%T", filter),
+		Other:     fmt.Sprintf("%T", other),
+	}
+}
+
+// Filter is a set membership filter
+type Filter interface {
+	// Add adds a new element into filter
+	Add(element []byte)
+	// Has checks if a element may exist in filter
+	Has(element []byte) bool
+	// AddHash adds a new element by its digest
+	AddHash(d Digest)
+	// HasHash checks if a element may exist in filter by its digest
+	HasHash(d Digest) bool
+}
+
+// Deletable is a filter which supports removing elements
+type Deletable interface {
+	Filter
+	// Remove removes a element from filter
+	Remove(element []byte)
+	// RemoveHash removes a element from filter by its digest
+	RemoveHash(d Digest)
+}
+
+// Mergeable is a filter which can take in elements of another filter
+type Mergeable interface {
+	Filter
+	// Merge adds all elements of other into filter
+	Merge(other Filter) error
+}
+
+// Estimator is a filter which reports its own accuracy
+type Estimator interface {
+	Filter
+	// GetFalsePositiveIncidence gets the incidence of false positive
+	GetFalsePositiveIncidence() float64
+	// Count gets the number of added elements
+	Count() int64
+}
+
+// Serializable is a filter which can be encoded to and decoded from stream
+type Serializable interface {
+	Filter
+	GobEncode() ([]byte, error)
+	GobDecode(data []byte) error
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	encoding.TextMarshaler
+	encoding.TextUnmarshaler
+	json.Marshaler
+	json.Unmarshaler
+	io.WriterTo
+	io.ReaderFrom
+	// WriteCompressedTo writes filter with bit maps compressed when they are smaller
+	WriteCompressedTo(w io.Writer) (int64, error)
+	// MarshalCompressed encodes filter with bit maps compressed when they are smaller
+	MarshalCompressed() ([]byte, error)
+}
+
+var (
+	_ Filter       = (*BloomFilter)(nil)
+	_ Mergeable    = (*BloomFilter)(nil)
+	_ Estimator    = (*BloomFilter)(nil)
+	_ Serializable = (*BloomFilter)(nil)
+
+	_ Filter       = (*AtomicFilter)(nil)
+	_ Estimator    = (*AtomicFilter)(nil)
+	_ Serializable = (*AtomicFilter)(nil)
+
+	_ Filter       = (*CountingFilter)(nil)
+	_ Deletable    = (*CountingFilter)(nil)
+	_ Mergeable    = (*CountingFilter)(nil)
+	_ Estimator    = (*CountingFilter)(nil)
+	_ Serializable = (*CountingFilter)(nil)
+
+	_ Filter       = (*PartitionedFilter)(nil)
+	_ Mergeable    = (*PartitionedFilter)(nil)
+	_ Estimator    = (*PartitionedFilter)(nil)
+	_ Serializable = (*PartitionedFilter)(nil)
+
+	_ Filter       = (*ShardedFilter)(nil)
+	_ Mergeable    = (*ShardedFilter)(nil)
+	_ Estimator    = (*ShardedFilter)(nil)
+	_ Serializable = (*ShardedFilter)(nil)
+
+	_ Filter       = (*ShardedCountingFilter)(nil)
+	_ Deletable    = (*ShardedCountingFilter)(nil)
+	_ Mergeable    = (*ShardedCountingFilter)(nil)
+	_ Estimator    = (*ShardedCountingFilter)(nil)
+	_ Serializable = (*ShardedCountingFilter)(nil)
+
+	_ Filter       = (*ScalableFilter)(nil)
+	_ Mergeable    = (*ScalableFilter)(nil)
+	_ Estimator    = (*ScalableFilter)(nil)
+	_ Serializable = (*ScalableFilter)(nil)
+)
@@ -0,0 +1,318 @@
+package blooms
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilter(t *testing.T) {
+	Convey("Given every filter type as Filter", t, func() {
+		filters := []Filter{
+			New(128, 3),
+			NewCountingFilter(128, 3),
+			NewPartitionedFilter(128, 3),
+			NewScalableFilter(128, 2, 0.01, 0.8),
+		}
+
+		Convey("When adding a element", func() {
+			for _, f := range filters {
+				f.Add([]byte("test"))
+			}
+
+			Convey("Then every filter should have it", func() {
+				for _, f := range filters {
+					So(f.Has([]byte("test")), ShouldBeTrue)
+					So(f.(Estimator).Count(), ShouldEqual, 1)
+					So(f.(Estimator).GetFalsePositiveIncidence(), ShouldBeBetween, 0, 1)
+				}
+
+			})
+		})
+
+		Convey("When removing a element from deletable filter", func() {
+			var d Deletable = NewCountingFilter(128, 3)
+			d.Add([]byte("test"))
+			d.Remove([]byte("test"))
+
+			Convey("Then element should not remain", func() {
+				So(d.Has([]byte("test")), ShouldBeFalse)
+
+			})
+		})
+	})
+}
+
+func TestBloomFilter_Merge(t *testing.T) {
+	Convey("Given two bloom filters with the same shape", t, func() {
+		a := New(128, 3)
+		b := New(128, 3)
+		a.Add([]byte("a"))
+		b.Add([]byte("b"))
+
+		Convey("When merging one into the other", func() {
+			err := a.Merge(b)
+
+			Convey("Then both elements should exist", func() {
+				So(err, ShouldBeNil)
+				So(a.Has([]byte("a")), ShouldBeTrue)
+				So(a.Has([]byte("b")), ShouldBeTrue)
+				So(a.Count(), ShouldEqual, 2)
+
+			})
+		})
+
+		Convey("When merging filters with different shape", func() {
+			err := a.Merge(New(256, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging another filter type", func() {
+			err := a.Merge(NewPartitionedFilter(128, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestPartitionedFilter_Merge(t *testing.T) {
+	Convey("Given two partitioned filters with the same shape", t, func() {
+		a := NewPartitionedFilter(128, 3)
+		b := NewPartitionedFilter(128, 3)
+		a.Add([]byte("a"))
+		b.Add([]byte("b"))
+
+		Convey("When merging one into the other", func() {
+			err := a.Merge(b)
+
+			Convey("Then both elements should exist", func() {
+				So(err, ShouldBeNil)
+				So(a.Has([]byte("a")), ShouldBeTrue)
+				So(a.Has([]byte("b")), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestBloomFilter_Union(t *testing.T) {
+	Convey("Given two bloom filters sharing half of elements", t, func() {
+		a := New(1<<14, 5)
+		b := New(1<<14, 5)
+		for i := 0; i < 1000; i++ {
+			a.Add([]byte(fmt.Sprint(i)))
+			b.Add([]byte(fmt.Sprint(i + 500)))
+		}
+
+		Convey("When taking union of them", func() {
+			u, err := a.Union(b)
+
+			Convey("Then every element should exist", func() {
+				So(err, ShouldBeNil)
+				for i := 0; i < 1500; i++ {
+					So(u.Has([]byte(fmt.Sprint(i))), ShouldBeTrue)
+				}
+
+			})
+
+			Convey("Then the number of elements should be re-estimated", func() {
+				So(u.Count(), ShouldAlmostEqual, 1500, 75)
+
+			})
+
+			Convey("Then operands should not change", func() {
+				So(a.Count(), ShouldEqual, 1000)
+				So(b.Count(), ShouldEqual, 1000)
+
+			})
+		})
+
+		Convey("When taking intersection of them", func() {
+			err := a.IntersectInPlace(b)
+
+			Convey("Then common elements should exist", func() {
+				So(err, ShouldBeNil)
+				for i := 500; i < 1000; i++ {
+					So(a.Has([]byte(fmt.Sprint(i))), ShouldBeTrue)
+				}
+				// Bits set by elements of either one remain as well,
+				// so the estimate is biased upward
+				So(a.Count(), ShouldBeBetween, 490, 600)
+
+			})
+		})
+
+		Convey("When combining filters with different shape", func() {
+			_, err := a.Intersect(New(1<<14, 4))
+
+			Convey("Then typed error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "k", Value: 5, Other: 4})
+
+			})
+		})
+	})
+}
+
+func TestPartitionedFilter_Union(t *testing.T) {
+	Convey("Given two partitioned filters sharing half of elements", t, func() {
+		a := NewPartitionedFilter(1<<14, 5)
+		b := NewPartitionedFilter(1<<14, 5)
+		for i := 0; i < 1000; i++ {
+			a.Add([]byte(fmt.Sprint(i)))
+			b.Add([]byte(fmt.Sprint(i + 500)))
+		}
+
+		Convey("When taking union and intersection of them", func() {
+			u, err := a.Union(b)
+			So(err, ShouldBeNil)
+			i, err := a.Intersect(b)
+			So(err, ShouldBeNil)
+
+			Convey("Then the number of elements should be re-estimated", func() {
+				So(u.Count(), ShouldAlmostEqual, 1500, 75)
+				So(i.Count(), ShouldBeBetween, 490, 600)
+				So(i.Has([]byte("700")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When combining filters with different partition size", func() {
+			err := a.UnionInPlace(NewPartitionedFilter(1<<14+5, 5))
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "m", Value: 1 << 14, Other: 1<<14 + 5})
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_GetFalsePositiveIncidence(t *testing.T) {
+	Convey("Given scalable filter grown to several filters", t, func() {
+		sf := NewScalableFilter(128, 2, 0.01, 0.8)
+		for i := 0; i < 1000; i++ {
+			sf.Add([]byte{byte(i), byte(i >> 8)})
+		}
+
+		Convey("When getting false positive incidence", func() {
+			fp := sf.GetFalsePositiveIncidence()
+
+			Convey("Then it should be at least the one of every filter", func() {
+				So(len(sf.filters), ShouldBeGreaterThan, 1)
+				for _, pf := range sf.filters {
+					So(fp, ShouldBeGreaterThanOrEqualTo, pf.GetFalsePositiveIncidence())
+				}
+				So(fp, ShouldBeLessThan, 1)
+
+			})
+		})
+	})
+}
+
+func TestFilter_AddHash(t *testing.T) {
+	Convey("Given every filter type and a digest of element", t, func() {
+		filters := []Filter{
+			New(128, 3),
+			NewCountingFilter(128, 3),
+			NewPartitionedFilter(128, 3),
+			NewScalableFilter(128, 2, 0.01, 0.8),
+		}
+		d := HashOf([]byte("test"))
+
+		Convey("When adding the digest", func() {
+			for _, f := range filters {
+				f.AddHash(d)
+			}
+
+			Convey("Then element should be found by both digest and element", func() {
+				for _, f := range filters {
+					So(f.HasHash(d), ShouldBeTrue)
+					So(f.Has([]byte("test")), ShouldBeTrue)
+					So(f.HasHash(HashOf([]byte("not_set"))), ShouldBeFalse)
+				}
+
+			})
+		})
+
+		Convey("When removing the digest from deletable filter", func() {
+			c := NewCountingFilter(128, 3)
+			c.Add([]byte("test"))
+			c.RemoveHash(d)
+
+			Convey("Then element should not remain", func() {
+				So(c.Has([]byte("test")), ShouldBeFalse)
+
+			})
+		})
+	})
+}
+
+func TestFilter_Allocs(t *testing.T) {
+	Convey("Given every filter type", t, func() {
+		filters := []Filter{
+			New(1024, 5),
+			NewCountingFilter(1024, 5),
+			NewPartitionedFilter(1024, 5),
+			NewScalableFilter(1024, 2, 0.01, 0.8),
+			New(1024, 5, WithHasher(FNV1aHasher)),
+			New(1024, 5, WithHasher(NewSipHasher([16]byte{1}))),
+		}
+		e := []byte("test")
+
+		Convey("When adding and checking a element", func() {
+			allocs := make([]float64, len(filters))
+			for i, f := range filters {
+				allocs[i] = testing.AllocsPerRun(100, func() {
+					f.Add(e)
+					f.Has(e)
+				})
+			}
+
+			Convey("Then nothing should be allocated", func() {
+				for i := range filters {
+					So(allocs[i], ShouldEqual, 0)
+				}
+
+			})
+		})
+	})
+}
+
+func BenchmarkBloomFilter_Has(b *testing.B) {
+	filters := make([]*BloomFilter, 32)
+	for i := range filters {
+		filters[i] = New(1<<16, 7)
+	}
+	e := []byte("test")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, f := range filters {
+			f.Has(e)
+		}
+	}
+}
+
+func BenchmarkBloomFilter_HasHash(b *testing.B) {
+	filters := make([]*BloomFilter, 32)
+	for i := range filters {
+		filters[i] = New(1<<16, 7)
+	}
+	e := []byte("test")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := HashOf(e)
+		for _, f := range filters {
+			f.HasHash(d)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package blooms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// newEmptyFilter creates a zero filter of kind to decode into
+func newEmptyFilter(kind uint8) Serializable {
+	switch kind % 7 {
+	case 0:
+		return &BloomFilter{}
+	case 1:
+		return &CountingFilter{}
+	case 2:
+		return &PartitionedFilter{}
+	case 3:
+		return &ScalableFilter{}
+	case 4:
+		return &ShardedFilter{}
+	case 5:
+		return &AtomicFilter{}
+	}
+	return &ShardedCountingFilter{}
+}
+
+// fuzzKey is the key of keyed filters to decode keyed data into
+var fuzzKey = [16]byte{1, 2, 3}
+
+// newKeyedFilter creates a filter of kind keyed with fuzzKey to decode keyed data into
+func newKeyedFilter(kind uint8) Serializable {
+	keyed := WithHasher(NewSipHasher(fuzzKey))
+	switch kind % 7 {
+	case 0:
+		return New(64, 1, keyed)
+	case 1:
+		return NewCountingFilter(64, 1, keyed)
+	case 2:
+		return NewPartitionedFilter(64, 1, keyed)
+	case 3:
+		return NewScalableFilter(64, 2, 0.1, 0.5, keyed)
+	case 4:
+		return NewShardedFilter(1, 64, 1, keyed)
+	case 5:
+		return NewAtomicFilter(64, 1, keyed)
+	}
+	return NewShardedCountingFilter(1, 64, 1, keyed)
+}
+
+// decodeAs decodes data of kind into an empty filter,
+// or into a filter keyed with fuzzKey if data holds keyed filter
+func decodeAs(kind uint8, decode func(f Serializable) error) (Serializable, error) {
+	f := newEmptyFilter(kind)
+	err := decode(f)
+	if err == ErrMissingKey {
+		f = newKeyedFilter(kind)
+		err = decode(f)
+	}
+	return f, err
+}
+
+// seedFilters gets filters of every kind in order of newEmptyFilter
+func seedFilters() []Serializable {
+	filters := []Serializable{
+		New(128, 3),
+		NewCountingFilter(64, 3),
+		NewPartitionedFilter(128, 3),
+		NewScalableFilter(64, 2, 0.1, 0.5, WithHasher(FNV1aHasher)),
+		NewShardedFilter(2, 64, 3),
+		NewAtomicFilter(128, 3, WithIndexStrategy(SeededHashing)),
+		NewShardedCountingFilter(2, 64, 3),
+	}
+	for _, f := range filters {
+		for _, e := range elementsOf(20) {
+			f.Add([]byte(e))
+		}
+	}
+	return filters
+}
+
+// seedKeyedFilters gets keyed filters of every kind in order of newEmptyFilter
+func seedKeyedFilters() []Serializable {
+	var filters []Serializable
+	for kind := uint8(0); kind < 7; kind++ {
+		f := newKeyedFilter(kind)
+		for _, e := range elementsOf(20) {
+			f.Add([]byte(e))
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// exercise calls methods of decoded filter, none of which may panic
+func exercise(t *testing.T, f Serializable) {
+	f.Add([]byte("fuzz"))
+	if !f.Has([]byte("fuzz")) {
+		t.Fatal("added element is missing")
+	}
+	f.Has([]byte("other"))
+	if e, ok := f.(Estimator); ok {
+		e.Count()
+		e.GetFalsePositiveIncidence()
+	}
+	if e, ok := f.(interface{ EstimateCount() Estimate }); ok {
+		e.EstimateCount()
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeAs(kindOf(f), func(d Serializable) error { return d.UnmarshalBinary(data) }); err != nil {
+		t.Fatalf("encoded filter is not decoded: %v", err)
+	}
+	data, err = f.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeAs(kindOf(f), func(d Serializable) error { return d.GobDecode(data) }); err != nil {
+		t.Fatalf("encoded filter is not decoded: %v", err)
+	}
+}
+
+// kindOf gets kind of newEmptyFilter for f
+func kindOf(f Serializable) uint8 {
+	for kind := uint8(0); kind < 7; kind++ {
+		if sameKind(f, newEmptyFilter(kind)) {
+			return kind
+		}
+	}
+	panic("unknown filter kind")
+}
+
+func sameKind(a, b Serializable) bool {
+	switch a.(type) {
+	case *BloomFilter:
+		_, ok := b.(*BloomFilter)
+		return ok
+	case *CountingFilter:
+		_, ok := b.(*CountingFilter)
+		return ok
+	case *PartitionedFilter:
+		_, ok := b.(*PartitionedFilter)
+		return ok
+	case *ScalableFilter:
+		_, ok := b.(*ScalableFilter)
+		return ok
+	case *ShardedFilter:
+		_, ok := b.(*ShardedFilter)
+		return ok
+	case *AtomicFilter:
+		_, ok := b.(*AtomicFilter)
+		return ok
+	}
+	_, ok := b.(*ShardedCountingFilter)
+	return ok
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	for kind, filter := range append(seedFilters(), seedKeyedFilters()...) {
+		data, err := filter.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(kind), data)
+		data, err = filter.MarshalCompressed()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(kind), data)
+	}
+	for kind, name := range []string{"bloom", "counting", "partitioned", "scalable", "sharded", "atomic"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name+".bin"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(kind), data)
+	}
+
+	// Compressed bit map of a forged size inflates beyond memory
+	defer func(max int) { maxInflatedSlots = max }(maxInflatedSlots)
+	maxInflatedSlots = 1 << 20
+	f.Fuzz(func(t *testing.T, kind uint8, data []byte) {
+		// Checksum is fixed up to reach validation beyond it
+		for _, data := range [][]byte{data, withChecksum(data)} {
+			filter, err := decodeAs(kind, func(f Serializable) error { return f.UnmarshalBinary(data) })
+			if err != nil {
+				continue
+			}
+			exercise(t, filter)
+			_, err = decodeAs(kind, func(f Serializable) error {
+				_, err := f.ReadFrom(bytes.NewReader(data))
+				return err
+			})
+			if err != nil {
+				t.Fatalf("data decoded by UnmarshalBinary is not read: %v", err)
+			}
+		}
+	})
+}
+
+// withChecksum replaces the last 4 bytes of data with checksum of the rest
+func withChecksum(data []byte) []byte {
+	if len(data) < 4 {
+		return data
+	}
+	fixed := append([]byte(nil), data...)
+	body := fixed[:len(fixed)-4]
+	binary.LittleEndian.PutUint32(fixed[len(body):], crc32.Checksum(body, castagnoli))
+	return fixed
+}
+
+func FuzzGobDecode(f *testing.F) {
+	for kind, filter := range append(seedFilters(), seedKeyedFilters()...) {
+		data, err := filter.GobEncode()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(kind), data)
+	}
+
+	f.Fuzz(func(t *testing.T, kind uint8, data []byte) {
+		filter, err := decodeAs(kind, func(f Serializable) error { return f.GobDecode(data) })
+		if err != nil {
+			return
+		}
+		exercise(t, filter)
+	})
+}
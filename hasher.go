@@ -0,0 +1,185 @@
+package blooms
+
+import (
+	"errors"
+	"hash"
+	"hash/fnv"
+	"sync"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// ErrUnknownHasher is returned when a stream refers to a hasher not registered
+var ErrUnknownHasher = errors.New("blooms: unknown hasher")
+
+// Hasher computes 64bit hash used to derive filter indices
+type Hasher interface {
+	// ID returns name identifying hash function in encoded filters
+	ID() string
+	// Sum64 computes 64bit hash of data
+	Sum64(data []byte) uint64
+}
+
+// Hasher128 is a Hasher which computes 128bit hash natively.
+// Hashers without it get the second 64bit derived from Sum64.
+type Hasher128 interface {
+	Hasher
+	// Sum128 computes 128bit hash of data
+	Sum128(data []byte) (h1, h2 uint64)
+}
+
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) ID() string { return "murmur3-64" }
+
+func (murmur3Hasher) Sum64(data []byte) uint64 {
+	return murmur3.Sum64(data)
+}
+
+// Sum128 computes 128bit murmur3 whose first half equals Sum64
+func (murmur3Hasher) Sum128(data []byte) (uint64, uint64) {
+	return murmur3.Sum128(data)
+}
+
+type murmur3x128Hasher struct{}
+
+func (murmur3x128Hasher) ID() string { return "murmur3-128" }
+
+// Sum64 folds 128bit murmur3 hash into 64bit
+func (murmur3x128Hasher) Sum64(data []byte) uint64 {
+	h1, h2 := murmur3.Sum128(data)
+	return h1 ^ h2
+}
+
+func (murmur3x128Hasher) Sum128(data []byte) (uint64, uint64) {
+	return murmur3.Sum128(data)
+}
+
+// hash64Hasher adapts hash.Hash64 to Hasher
+type hash64Hasher struct {
+	id  string
+	new func() hash.Hash64
+	// Pool of hash.Hash64 to reuse them without allocation
+	pool sync.Pool
+}
+
+func (h *hash64Hasher) ID() string { return h.id }
+
+func (h *hash64Hasher) Sum64(data []byte) uint64 {
+	hasher := h.pool.Get().(hash.Hash64)
+	hasher.Reset()
+	hasher.Write(data)
+	sum := hasher.Sum64()
+	h.pool.Put(hasher)
+	return sum
+}
+
+// NewHash64Hasher creates a Hasher from constructor of hash.Hash64
+// such as fnv.New64a or xxhash.New
+func NewHash64Hasher(id string, newHash func() hash.Hash64) Hasher {
+	h := &hash64Hasher{
+		id:  id,
+		new: newHash,
+	}
+	h.pool.New = func() interface{} {
+		return h.new()
+	}
+	return h
+}
+
+var (
+	// Murmur3Hasher is 64bit murmur3 and is used by default
+	Murmur3Hasher Hasher = murmur3Hasher{}
+	// Murmur3x128Hasher is 128bit murmur3 folded into 64bit
+	Murmur3x128Hasher Hasher = murmur3x128Hasher{}
+	// FNV1aHasher is 64bit FNV-1a
+	FNV1aHasher = NewHash64Hasher("fnv1a-64", fnv.New64a)
+
+	// DefaultHasher is used when no hasher is given
+	DefaultHasher = Murmur3Hasher
+)
+
+// mix64 is finalizer of murmur3 to derive an independent 64bit from h
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// sum128 computes 128bit hash of data with h
+func sum128(h Hasher, data []byte) (uint64, uint64) {
+	if h128, ok := h.(Hasher128); ok {
+		return h128.Sum128(data)
+	}
+	h1 := h.Sum64(data)
+	return h1, mix64(h1 ^ 0x9e3779b97f4a7c15)
+}
+
+// Digest is 128bit hash of element.
+// It can be computed once and shared by filters with the same hasher.
+type Digest struct {
+	h1, h2 uint64
+}
+
+// HashOf computes digest of element with default hasher
+func HashOf(element []byte) Digest {
+	return HashWith(DefaultHasher, element)
+}
+
+// HashWith computes digest of element with h
+func HashWith(h Hasher, element []byte) Digest {
+	h1, h2 := sum128(h, element)
+	return Digest{h1: h1, h2: h2}
+}
+
+// seed derives another digest of element for seed.
+// Digest with seed 0 is itself.
+func (d Digest) seed(seed uint64) Digest {
+	if seed == 0 {
+		return d
+	}
+	return Digest{
+		h1: mix64(d.h1 ^ seed),
+		h2: mix64(d.h2 + seed),
+	}
+}
+
+var hashers = struct {
+	sync.RWMutex
+	m map[string]Hasher
+}{
+	m: map[string]Hasher{},
+}
+
+func init() {
+	RegisterHasher(Murmur3Hasher)
+	RegisterHasher(Murmur3x128Hasher)
+	RegisterHasher(FNV1aHasher)
+	RegisterHasher(&sipHasher{})
+}
+
+// RegisterHasher registers hasher so that filters encoded with it can be decoded.
+// Hasher with the same ID is replaced.
+func RegisterHasher(h Hasher) {
+	hashers.Lock()
+	defer hashers.Unlock()
+	hashers.m[h.ID()] = h
+}
+
+// lookupHasher gets a registered hasher by ID.
+// Empty ID means a stream encoded before hashers were recorded.
+func lookupHasher(id string) (Hasher, error) {
+	if id == "" {
+		return Murmur3Hasher, nil
+	}
+	hashers.RLock()
+	defer hashers.RUnlock()
+	h, ok := hashers.m[id]
+	if !ok {
+		return nil, ErrUnknownHasher
+	}
+	return h, nil
+}
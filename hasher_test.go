@@ -0,0 +1,162 @@
+package blooms
+
+import (
+	"errors"
+	"hash"
+	"hash/crc64"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewHash64Hasher(t *testing.T) {
+	Convey("Given constructor of hash.Hash64", t, func() {
+		newHash := func() hash.Hash64 {
+			return crc64.New(crc64.MakeTable(crc64.ECMA))
+		}
+
+		Convey("When creating a hasher", func() {
+			h := NewHash64Hasher("crc64-ecma", newHash)
+
+			Convey("Then hasher should compute the same hash", func() {
+				So(h.ID(), ShouldEqual, "crc64-ecma")
+				So(h.Sum64([]byte("test")), ShouldEqual, crc64.Checksum([]byte("test"), crc64.MakeTable(crc64.ECMA)))
+				So(h.Sum64([]byte("test")), ShouldEqual, h.Sum64([]byte("test")))
+
+			})
+		})
+	})
+}
+
+func TestLookupHasher(t *testing.T) {
+	Convey("Given built-in hashers", t, func() {
+		builtins := []Hasher{Murmur3Hasher, Murmur3x128Hasher, FNV1aHasher}
+
+		Convey("When looking up by ID", func() {
+			Convey("Then the same hasher should be returned", func() {
+				for _, h := range builtins {
+					res, err := lookupHasher(h.ID())
+					So(err, ShouldBeNil)
+					So(res.ID(), ShouldEqual, h.ID())
+				}
+
+			})
+		})
+
+		Convey("When looking up empty ID", func() {
+			res, err := lookupHasher("")
+
+			Convey("Then murmur3 should be returned", func() {
+				So(err, ShouldBeNil)
+				So(res.ID(), ShouldEqual, Murmur3Hasher.ID())
+
+			})
+		})
+
+		Convey("When looking up unregistered ID", func() {
+			_, err := lookupHasher("unregistered")
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrUnknownHasher)
+
+			})
+		})
+	})
+}
+
+func TestWithHasher(t *testing.T) {
+	Convey("Given filters with FNV-1a hasher", t, func() {
+		b := New(128, 3, WithHasher(FNV1aHasher))
+		sf := NewScalableFilter(128, 2, 0.01, 0.8, WithHasher(FNV1aHasher))
+		b.Add([]byte("test"))
+		sf.Add([]byte("test"))
+
+		Convey("When decoding gobs stream", func() {
+			buf, _ := b.GobEncode()
+			res := &BloomFilter{}
+			err := res.GobDecode(buf)
+
+			sbuf, _ := sf.GobEncode()
+			sres := &ScalableFilter{}
+			serr := sres.GobDecode(sbuf)
+
+			Convey("Then hasher should be restored", func() {
+				So(err, ShouldBeNil)
+				So(res.hasher, ShouldEqual, FNV1aHasher)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+				So(serr, ShouldBeNil)
+				So(sres.hasher, ShouldEqual, FNV1aHasher)
+				So(sres.filters[0].hasher, ShouldEqual, FNV1aHasher)
+				So(sres.Has([]byte("test")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding gobs stream with unregistered hasher", func() {
+			custom := NewHash64Hasher("unregistered", FNV1aHasher.(*hash64Hasher).new)
+			buf, _ := New(128, 3, WithHasher(custom)).GobEncode()
+			err := (&BloomFilter{}).GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrUnknownHasher)
+
+			})
+		})
+
+		Convey("When merging filter with another hasher", func() {
+			err := b.Merge(New(128, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestSum128(t *testing.T) {
+	Convey("Given element", t, func() {
+		e := []byte("test")
+
+		Convey("When computing 128bit hash with murmur3", func() {
+			h1, h2 := sum128(Murmur3Hasher, e)
+
+			Convey("Then first half should equal 64bit hash", func() {
+				So(h1, ShouldEqual, Murmur3Hasher.Sum64(e))
+				So(h2, ShouldNotEqual, h1)
+
+			})
+		})
+
+		Convey("When computing 128bit hash with 64bit only hasher", func() {
+			h1, h2 := sum128(FNV1aHasher, e)
+
+			Convey("Then second half should be derived from the first", func() {
+				So(h1, ShouldEqual, FNV1aHasher.Sum64(e))
+				So(h2, ShouldNotEqual, h1)
+				_, again := sum128(FNV1aHasher, e)
+				So(again, ShouldEqual, h2)
+
+			})
+		})
+	})
+}
+
+func TestHashWith(t *testing.T) {
+	Convey("Given element", t, func() {
+		e := []byte("test")
+
+		Convey("When computing digest", func() {
+			d := HashOf(e)
+			fd := HashWith(FNV1aHasher, e)
+
+			Convey("Then it should hold 128bit hash of the hasher", func() {
+				h1, h2 := sum128(DefaultHasher, e)
+				So(d, ShouldResemble, Digest{h1: h1, h2: h2})
+				So(fd.h1, ShouldEqual, FNV1aHasher.Sum64(e))
+				So(fd, ShouldNotResemble, d)
+
+			})
+		})
+	})
+}
@@ -0,0 +1,505 @@
+package blooms
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// JSON representation of filters.
+// Parameters are explicit fields and bit map or counters are a base64 payload,
+// which is packed as payload of binary format.
+//
+//	{"type":"bloom","m":64,"k":3,"n":1,"hasher":"murmur3-64","strategy":"enhanced-double","payload":"AAIAAAgAAEA="}
+//
+// Partitioned filter has s, maxN and p in addition.
+// Scalable filter has its parameters and stages of partitioned filters without type.
+// Sharded filter has counting and shards of base filters without type.
+// Text representation is base64 of binary format, so that filter fits in a single config value.
+
+// filterTypeNames are names of filter types in JSON
+var filterTypeNames = map[filterType]string{
+	typeBloom:       "bloom",
+	typeCounting:    "counting",
+	typePartitioned: "partitioned",
+	typeScalable:    "scalable",
+	typeSharded:     "sharded",
+	typeAtomic:      "atomic",
+}
+
+func (t filterType) String() string {
+	return filterTypeNames[t]
+}
+
+// filterJSON is JSON representation of filters, stages and shards.
+// 64bit values are strings not to lose precision in JSON numbers.
+type filterJSON struct {
+	Type        string        `json:"type,omitempty"`
+	M           int           `json:"m,omitempty"`
+	K           int           `json:"k,omitempty"`
+	S           int           `json:"s,omitempty"`
+	N           int64         `json:"n"`
+	MaxN        int           `json:"maxN,omitempty"`
+	P           float64       `json:"p,omitempty"`
+	GrowthRate  int           `json:"growthRate,omitempty"`
+	FpReduction float64       `json:"fpReduction,omitempty"`
+	Seed        uint64        `json:"seed,string,omitempty"`
+	Hasher      string        `json:"hasher,omitempty"`
+	KeyCheck    uint64        `json:"keyCheck,string,omitempty"`
+	Strategy    string        `json:"strategy,omitempty"`
+	Counting    bool          `json:"counting,omitempty"`
+	Stages      []*filterJSON `json:"stages,omitempty"`
+	Shards      []*filterJSON `json:"shards,omitempty"`
+	Payload     []byte        `json:"payload,omitempty"`
+}
+
+// baseJSON gets JSON of base filter with seed of digest while holding lock
+func baseJSON(b *baseFilter, seed uint64) *filterJSON {
+	j := &filterJSON{
+		M:        b.m,
+		K:        b.k,
+		S:        b.s,
+		N:        int64(b.n),
+		Seed:     seed,
+		Hasher:   b.hasherID(),
+		KeyCheck: keyCheck(b.hasher),
+		Strategy: b.strategyID(),
+	}
+	switch bits := b.bits.(type) {
+	case bitSet:
+		j.Payload = wordBytes(bits)
+	case counterSet:
+		j.Payload = append([]byte(nil), bits...)
+	}
+	return j
+}
+
+// partitionedJSON gets JSON of partitioned filter while holding lock
+func partitionedJSON(p *PartitionedFilter) *filterJSON {
+	j := baseJSON(p.baseFilter, p.seed)
+	j.MaxN = p.maxN
+	j.P = p.p
+	return j
+}
+
+// wordBytes packs words of bit map in little endian
+func wordBytes(words bitSet) []byte {
+	p := make([]byte, len(words)*8)
+	for i, w := range words {
+		binary.LittleEndian.PutUint64(p[i*8:], w)
+	}
+	return p
+}
+
+// base gets base filter of JSON.
+// Parameters are validated before payload as binary format.
+func (j *filterJSON) base(counting bool) (*baseFilter, error) {
+	if j == nil {
+		return nil, invalid("Base", "is missing")
+	}
+	hasher, err := lookupHasher(j.Hasher)
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := lookupIndexStrategy(j.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	b := &baseFilter{
+		m:        j.M,
+		k:        j.K,
+		n:        int(j.N),
+		s:        j.S,
+		hasher:   withKeyCheck(hasher, j.KeyCheck),
+		strategy: strategy,
+	}
+	if int64(b.n) != j.N {
+		return nil, invalid("N", "is too large, %d", j.N)
+	}
+	err = b.validateParams()
+	if err != nil {
+		return nil, err
+	}
+
+	if counting {
+		if len(j.Payload) != b.m {
+			return nil, invalid("Payload", "must be %d counters, but %d bytes", b.m, len(j.Payload))
+		}
+		b.bits = counterSet(j.Payload)
+	} else {
+		// Check length before allocating not to trust a forged size
+		if len(j.Payload) != wordNumber(b.m)*8 {
+			return nil, invalid("Payload", "must be %d words, but %d bytes", wordNumber(b.m), len(j.Payload))
+		}
+		words := make(bitSet, wordNumber(b.m))
+		for i := range words {
+			words[i] = binary.LittleEndian.Uint64(j.Payload[i*8:])
+		}
+		b.bits = words
+	}
+	return b, b.validate()
+}
+
+// partitioned gets partitioned filter of JSON
+func (j *filterJSON) partitioned() (*PartitionedFilter, error) {
+	base, err := j.base(false)
+	if err != nil {
+		return nil, err
+	}
+	pf := &PartitionedFilter{
+		baseFilter: base,
+		maxN:       j.MaxN,
+		p:          j.P,
+		seed:       j.Seed,
+	}
+	return pf, pf.validate()
+}
+
+// unmarshalJSON decodes JSON of filter of type t
+func unmarshalJSON(data []byte, t filterType) (*filterJSON, error) {
+	var j filterJSON
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return nil, err
+	}
+	if j.Type != t.String() {
+		return nil, ErrFilterType
+	}
+	return &j, nil
+}
+
+// marshalText encodes binary format of filter in base64
+func marshalText(data []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	text := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(text, data)
+	return text, nil
+}
+
+// unmarshalText decodes base64 of binary format of filter
+func unmarshalText(text []byte) ([]byte, error) {
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(text)))
+	n, err := base64.StdEncoding.Decode(data, text)
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	return data[:n], nil
+}
+
+// MarshalJSON encodes filter in JSON
+func (b *BloomFilter) MarshalJSON() ([]byte, error) {
+	b.mu.RLock()
+	j := baseJSON(b.baseFilter, 0)
+	b.mu.RUnlock()
+	j.Type = typeBloom.String()
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (b *BloomFilter) UnmarshalJSON(data []byte) error {
+	j, err := unmarshalJSON(data, typeBloom)
+	if err != nil {
+		return err
+	}
+	base, err := j.base(false)
+	if err != nil {
+		return err
+	}
+	return setBase(&b.baseFilter, base)
+}
+
+// MarshalText encodes filter in base64 of binary format
+func (b *BloomFilter) MarshalText() ([]byte, error) {
+	return marshalText(b.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (b *BloomFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return b.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes filter in JSON
+func (c *CountingFilter) MarshalJSON() ([]byte, error) {
+	c.mu.RLock()
+	j := baseJSON(c.baseFilter, 0)
+	c.mu.RUnlock()
+	j.Type = typeCounting.String()
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (c *CountingFilter) UnmarshalJSON(data []byte) error {
+	j, err := unmarshalJSON(data, typeCounting)
+	if err != nil {
+		return err
+	}
+	base, err := j.base(true)
+	if err != nil {
+		return err
+	}
+	return setBase(&c.baseFilter, base)
+}
+
+// MarshalText encodes filter in base64 of binary format
+func (c *CountingFilter) MarshalText() ([]byte, error) {
+	return marshalText(c.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (c *CountingFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes a snapshot of filter in JSON
+func (a *AtomicFilter) MarshalJSON() ([]byte, error) {
+	j := &filterJSON{
+		Type:     typeAtomic.String(),
+		M:        a.m,
+		K:        a.k,
+		N:        a.Count(),
+		Hasher:   a.hasherID(),
+		KeyCheck: keyCheck(a.hasher),
+		Strategy: a.strategyID(),
+		Payload:  wordBytes(a.bits.(bitSet).snapshot()),
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON.
+// It must not be called concurrently with other methods.
+func (a *AtomicFilter) UnmarshalJSON(data []byte) error {
+	j, err := unmarshalJSON(data, typeAtomic)
+	if err != nil {
+		return err
+	}
+	base, err := j.base(false)
+	if err != nil {
+		return err
+	}
+	return a.load(base)
+}
+
+// MarshalText encodes a snapshot of filter in base64 of binary format
+func (a *AtomicFilter) MarshalText() ([]byte, error) {
+	return marshalText(a.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format.
+// It must not be called concurrently with other methods.
+func (a *AtomicFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return a.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes filter in JSON
+func (p *PartitionedFilter) MarshalJSON() ([]byte, error) {
+	p.mu.RLock()
+	j := partitionedJSON(p)
+	p.mu.RUnlock()
+	j.Type = typePartitioned.String()
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (p *PartitionedFilter) UnmarshalJSON(data []byte) error {
+	j, err := unmarshalJSON(data, typePartitioned)
+	if err != nil {
+		return err
+	}
+	pf, err := j.partitioned()
+	if err != nil {
+		return err
+	}
+	return p.load(pf)
+}
+
+// MarshalText encodes filter in base64 of binary format
+func (p *PartitionedFilter) MarshalText() ([]byte, error) {
+	return marshalText(p.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (p *PartitionedFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return p.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes filter in JSON with its filters as stages
+func (sf *ScalableFilter) MarshalJSON() ([]byte, error) {
+	sf.mu.RLock()
+	j := &filterJSON{
+		Type:        typeScalable.String(),
+		M:           sf.m,
+		K:           sf.k,
+		N:           sf.n,
+		MaxN:        sf.maxN,
+		P:           sf.p,
+		GrowthRate:  sf.growthRate,
+		FpReduction: sf.fpReduction,
+		Seed:        sf.seed,
+		Hasher:      sf.hasher.ID(),
+		KeyCheck:    keyCheck(sf.hasher),
+		Strategy:    sf.strategy.ID(),
+		Stages:      make([]*filterJSON, len(sf.filters)),
+	}
+	for i, pf := range sf.filters {
+		pf.mu.RLock()
+		j.Stages[i] = partitionedJSON(pf)
+		pf.mu.RUnlock()
+	}
+	sf.mu.RUnlock()
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (sf *ScalableFilter) UnmarshalJSON(data []byte) error {
+	j, err := unmarshalJSON(data, typeScalable)
+	if err != nil {
+		return err
+	}
+	hasher, err := lookupHasher(j.Hasher)
+	if err != nil {
+		return err
+	}
+	strategy, err := lookupIndexStrategy(j.Strategy)
+	if err != nil {
+		return err
+	}
+	d := &ScalableFilter{
+		filters:     make(PartitionedFilters, len(j.Stages)),
+		hasher:      withKeyCheck(hasher, j.KeyCheck),
+		strategy:    strategy,
+		seed:        j.Seed,
+		k:           j.K,
+		m:           j.M,
+		n:           j.N,
+		maxN:        j.MaxN,
+		p:           j.P,
+		growthRate:  j.GrowthRate,
+		fpReduction: j.FpReduction,
+	}
+	for i, stage := range j.Stages {
+		d.filters[i], err = stage.partitioned()
+		if err != nil {
+			return nested("Stages", i, err)
+		}
+	}
+	err = d.validate()
+	if err != nil {
+		return err
+	}
+	return sf.load(d)
+}
+
+// MarshalText encodes filter in base64 of binary format
+func (sf *ScalableFilter) MarshalText() ([]byte, error) {
+	return marshalText(sf.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (sf *ScalableFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return sf.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes filter in JSON with its shards.
+// All shards are read locked while encoding to take a consistent snapshot.
+func (sf *shardedBase) MarshalJSON() ([]byte, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	j := &filterJSON{
+		Type:     typeSharded.String(),
+		Counting: sf.counting,
+		Shards:   make([]*filterJSON, len(sf.shards)),
+	}
+	for i, s := range sf.shards {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		j.Shards[i] = baseJSON(s, 0)
+		j.N += j.Shards[i].N
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (sf *ShardedFilter) UnmarshalJSON(data []byte) error {
+	return sf.unmarshalJSON(data, false)
+}
+
+// UnmarshalJSON decodes filter in JSON
+func (sf *ShardedCountingFilter) UnmarshalJSON(data []byte) error {
+	return sf.unmarshalJSON(data, true)
+}
+
+// unmarshalJSON decodes sharded filter whose shards are counting or not
+func (sf *shardedBase) unmarshalJSON(data []byte, counting bool) error {
+	j, err := unmarshalJSON(data, typeSharded)
+	if err != nil {
+		return err
+	}
+	if j.Counting != counting {
+		return ErrFilterType
+	}
+	d := &shardedBase{
+		shards:   make([]*baseFilter, len(j.Shards)),
+		counting: counting,
+	}
+	var n int64
+	for i, shard := range j.Shards {
+		d.shards[i], err = shard.base(counting)
+		if err != nil {
+			return nested("Shards", i, err)
+		}
+		n += int64(d.shards[i].n)
+	}
+	err = d.validate()
+	if err != nil {
+		return err
+	}
+	if n != j.N {
+		return invalid("N", "must be sum of shards %d, but %d", n, j.N)
+	}
+	return sf.load(d)
+}
+
+// MarshalText encodes filter in base64 of binary format
+func (sf *shardedBase) MarshalText() ([]byte, error) {
+	return marshalText(sf.MarshalBinary())
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (sf *ShardedFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return sf.UnmarshalBinary(data)
+}
+
+// UnmarshalText decodes filter in base64 of binary format
+func (sf *ShardedCountingFilter) UnmarshalText(text []byte) error {
+	data, err := unmarshalText(text)
+	if err != nil {
+		return err
+	}
+	return sf.UnmarshalBinary(data)
+}
@@ -0,0 +1,191 @@
+package blooms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	Convey("Given filters of golden vectors", t, func() {
+		for _, v := range goldenVectors {
+			f := v.filter()
+			for _, e := range v.elements {
+				f.Add([]byte(e))
+			}
+			data, err := f.MarshalBinary()
+			So(err, ShouldBeNil)
+
+			Convey(fmt.Sprintf("When encoding %s filter in JSON", v.name), func() {
+				j, err := json.Marshal(f)
+				So(err, ShouldBeNil)
+				var fields map[string]interface{}
+				So(json.Unmarshal(j, &fields), ShouldBeNil)
+				d := v.filter()
+				err = json.Unmarshal(j, d)
+
+				Convey("Then it should have explicit fields and round trip losslessly", func() {
+					So(fields["type"], ShouldEqual, v.name)
+					So(fields["n"], ShouldEqual, len(v.elements))
+					So(err, ShouldBeNil)
+					for _, e := range v.elements {
+						So(d.Has([]byte(e)), ShouldBeTrue)
+					}
+					decoded, err := d.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(bytes.Equal(decoded, data), ShouldBeTrue)
+
+				})
+			})
+
+			Convey(fmt.Sprintf("When encoding %s filter in text", v.name), func() {
+				text, err := f.MarshalText()
+				So(err, ShouldBeNil)
+				d := v.filter()
+				err = d.UnmarshalText(text)
+
+				Convey("Then it should round trip losslessly", func() {
+					So(err, ShouldBeNil)
+					decoded, err := d.MarshalBinary()
+					So(err, ShouldBeNil)
+					So(bytes.Equal(decoded, data), ShouldBeTrue)
+
+				})
+			})
+		}
+	})
+}
+
+func TestUnmarshalJSON_Invalid(t *testing.T) {
+	Convey("Given JSON breaking invariants", t, func() {
+		b := New(128, 3)
+		b.Add([]byte("kept"))
+		valid := func() map[string]interface{} {
+			j, err := json.Marshal(b)
+			So(err, ShouldBeNil)
+			var fields map[string]interface{}
+			So(json.Unmarshal(j, &fields), ShouldBeNil)
+			return fields
+		}
+		cases := []struct {
+			name   string
+			fields func() map[string]interface{}
+			field  string
+		}{
+			{"no hash function", func() map[string]interface{} { f := valid(); f["k"] = 0; return f }, "K"},
+			{"negative size", func() map[string]interface{} { f := valid(); f["m"] = -1; return f }, "M"},
+			{"short payload", func() map[string]interface{} { f := valid(); f["payload"] = "AAAA"; return f }, "Payload"},
+			{"no payload", func() map[string]interface{} { f := valid(); delete(f, "payload"); return f }, "Payload"},
+			{"partitions over bit map", func() map[string]interface{} { f := valid(); f["s"] = 43; return f }, "S"},
+		}
+
+		Convey("When decoding them", func() {
+			for _, c := range cases {
+				j, err := json.Marshal(c.fields())
+				So(err, ShouldBeNil)
+				err = b.UnmarshalJSON(j)
+
+				Convey(fmt.Sprintf("Then typed error should be returned for %s", c.name), func() {
+					So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+					So(err.(*InvalidFilterError).Field, ShouldEqual, c.field)
+					So(b.Has([]byte("kept")), ShouldBeTrue)
+
+				})
+			}
+		})
+
+		Convey("When decoding JSON of another type", func() {
+			f := valid()
+			f["type"] = "counting"
+			j, err := json.Marshal(f)
+			So(err, ShouldBeNil)
+			err = b.UnmarshalJSON(j)
+
+			Convey("Then ErrFilterType should be returned", func() {
+				So(err, ShouldEqual, ErrFilterType)
+
+			})
+		})
+
+		Convey("When decoding text which is not base64", func() {
+			err := b.UnmarshalText([]byte("not base64!"))
+
+			Convey("Then ErrInvalidFormat should be returned", func() {
+				So(err, ShouldEqual, ErrInvalidFormat)
+
+			})
+		})
+	})
+
+	Convey("Given JSON of scalable filter with an invalid stage", t, func() {
+		sf := NewScalableFilter(64, 2, 0.1, 0.5)
+		for i := 0; i < 20; i++ {
+			sf.Add([]byte{byte(i)})
+		}
+		var j filterJSON
+		data, err := json.Marshal(sf)
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(data, &j), ShouldBeNil)
+		j.Stages[1].K = 0
+		data, err = json.Marshal(j)
+		So(err, ShouldBeNil)
+
+		Convey("When decoding it", func() {
+			err := json.Unmarshal(data, sf)
+
+			Convey("Then error should point the stage", func() {
+				So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+				So(err.(*InvalidFilterError).Field, ShouldEqual, "Stages[1].K")
+
+			})
+		})
+	})
+
+	Convey("Given JSON of scalable filter with a huge growth rate", t, func() {
+		sf := NewScalableFilter(64, 2, 0.1, 0.5)
+		var j filterJSON
+		data, err := json.Marshal(sf)
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(data, &j), ShouldBeNil)
+		j.GrowthRate = 1 << 55
+		data, err = json.Marshal(j)
+		So(err, ShouldBeNil)
+
+		Convey("When decoding it", func() {
+			d := &ScalableFilter{}
+			err := json.Unmarshal(data, d)
+
+			Convey("Then it should be refused before growing", func() {
+				So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+				So(err.(*InvalidFilterError).Field, ShouldEqual, "GrowthRate")
+
+			})
+		})
+	})
+
+	Convey("Given JSON of sharded filter with a wrong count", t, func() {
+		sf := NewShardedFilter(2, 64, 3)
+		sf.Add([]byte("a"))
+		var j filterJSON
+		data, err := json.Marshal(sf)
+		So(err, ShouldBeNil)
+		So(json.Unmarshal(data, &j), ShouldBeNil)
+		j.N = 2
+		data, err = json.Marshal(j)
+		So(err, ShouldBeNil)
+
+		Convey("When decoding it", func() {
+			err := json.Unmarshal(data, sf)
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+				So(err.(*InvalidFilterError).Field, ShouldEqual, "N")
+
+			})
+		})
+	})
+}
@@ -0,0 +1,45 @@
+package blooms
+
+// options holds optional settings for filter constructors
+type options struct {
+	hasher   Hasher
+	strategy IndexStrategy
+	seed     uint64
+}
+
+// Option configures a filter on creation
+type Option func(*options)
+
+// WithHasher sets hash function of filter
+func WithHasher(h Hasher) Option {
+	return func(o *options) {
+		o.hasher = h
+	}
+}
+
+// WithIndexStrategy sets derivation of indices from hash
+func WithIndexStrategy(s IndexStrategy) Option {
+	return func(o *options) {
+		o.strategy = s
+	}
+}
+
+// WithSeed sets seed from which every filter of scalable filter
+// derives its own seed
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
+// newOptions applies opts over default settings
+func newOptions(opts ...Option) *options {
+	o := &options{
+		hasher:   DefaultHasher,
+		strategy: DefaultIndexStrategy,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
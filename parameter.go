@@ -22,3 +22,62 @@ func GetBestElementNumber(m int, p float64) int {
 func GetBestFilterSize(n int, p float64) int {
 	return int(float64(n)*math.Abs(math.Log(p))/math.Pow(math.Log(2), 2) + 1)
 }
+
+// GetCompressedFilterSize compute the expected size in bits of filter (m)
+// of element number (n) and hasher number (k)
+// when its bit map is compressed with Golomb-Rice coding unless raw is smaller
+func GetCompressedFilterSize(m, k, n int) int {
+	// Fraction of zero bits, and gaps between set bits are geometric with it
+	q := math.Exp(-float64(k) * float64(n) / float64(m))
+	count := float64(m) * (1 - q)
+	if count == 0 {
+		return 0
+	}
+	best := float64(m)
+	for r := 0; r < maxRice; r++ {
+		// Unary part of gap is j or more with probability q^(j*2^r)
+		t := math.Pow(q, math.Exp2(float64(r)))
+		size := count * (float64(r+1) + t/(1-t))
+		if size < best {
+			best = size
+		}
+	}
+	return int(math.Ceil(best))
+}
+
+// GetBestCompressedParameters compute filter size (m) and hasher number (k)
+// which minimize false positive incidence of element number (n)
+// when filter is compressed within transmission size (z) in bits.
+// As Mitzenmacher's compressed bloomfilters, it takes a larger and sparser filter
+// with fewer hashers than the best filter of size z.
+// Filter in memory can be far larger than z.
+func GetBestCompressedParameters(n, z int) (int, int) {
+	if n <= 0 {
+		return z, 1
+	}
+	bestM, bestK := z, 1
+	best := math.Inf(1)
+	// The best hasher number without compression is the upper bound
+	maxK := int(math.Ceil(math.Ln2 * float64(z) / float64(n)))
+	for k := 1; k <= maxK; k++ {
+		// Compressed size grows with filter size,
+		// so find the largest filter within z which is never larger than z raw
+		lo, hi := z, 2*z
+		for hi <= maxSlots/2 && GetCompressedFilterSize(hi, k, n) <= z {
+			lo, hi = hi, 2*hi
+		}
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if GetCompressedFilterSize(mid, k, n) <= z {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		fp := getFalsePositiveIncidence(k, n, lo)
+		if fp < best {
+			bestM, bestK, best = lo, k, fp
+		}
+	}
+	return bestM, bestK
+}
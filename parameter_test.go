@@ -56,3 +56,34 @@ func TestGetBestFilterSize(t *testing.T) {
 		})
 	})
 }
+
+func TestGetBestCompressedParameters(t *testing.T) {
+	Convey("Given element number and transmission size of 8 bits per element", t, func() {
+		n := 10000
+		z := 8 * n
+
+		Convey("When getting parameters of compressed filter", func() {
+			m, k := GetBestCompressedParameters(n, z)
+
+			Convey("Then filter should be larger and more accurate than the best filter of size z", func() {
+				So(m, ShouldBeGreaterThan, z)
+				So(k, ShouldBeLessThan, 6)
+				So(getFalsePositiveIncidence(k, n, m), ShouldBeLessThan, getFalsePositiveIncidence(6, n, z))
+				So(GetCompressedFilterSize(m, k, n), ShouldBeLessThanOrEqualTo, z)
+
+			})
+
+			Convey("Then compressed filter should be within transmission size", func() {
+				b := New(m, k)
+				for _, e := range elementsOf(n) {
+					b.Add([]byte(e))
+				}
+				data, err := b.MarshalCompressed()
+				So(err, ShouldBeNil)
+				// Header and parameters take about 100 bytes
+				So(len(data)*8, ShouldBeLessThan, z+1024)
+
+			})
+		})
+	})
+}
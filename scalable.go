@@ -1,6 +1,9 @@
 package blooms
 
-import "math"
+import (
+	"math"
+	"sync"
+)
 
 // PartitionedFilter is implementation of partitioned bloomfilter
 type PartitionedFilter struct {
@@ -9,6 +12,10 @@ type PartitionedFilter struct {
 	maxN int
 	// Expected incidence of flase positive as origin
 	p float64
+	// Seed of digest as a stage of scalable filter
+	seed uint64
+	// Level as a stage of scalable filter, whose seed is derived from it
+	level int
 }
 
 type partitionedGobs struct {
@@ -18,12 +25,17 @@ type partitionedGobs struct {
 }
 
 // NewPartitionedFilter creates a new partitioned bloomfilter instance
-func NewPartitionedFilter(filterSize, hasherNumber int) *PartitionedFilter {
+func NewPartitionedFilter(filterSize, hasherNumber int, opts ...Option) *PartitionedFilter {
+	o := newOptions(opts...)
+	filterSize, hasherNumber = clampParams(filterSize, hasherNumber)
 	return &PartitionedFilter{
 		baseFilter: &baseFilter{
-			bits: make([]uint8, filterSize),
-			k:    hasherNumber,
-			s:    int(filterSize / hasherNumber),
+			bits:     newBitSet(filterSize),
+			m:        filterSize,
+			k:        hasherNumber,
+			s:        int(filterSize / hasherNumber),
+			hasher:   o.hasher,
+			strategy: o.strategy,
 		},
 	}
 }
@@ -36,12 +48,83 @@ func (p *PartitionedFilter) toGobs() *partitionedGobs {
 	}
 }
 
-func (p *partitionedGobs) toFilter() *PartitionedFilter {
-	return &PartitionedFilter{
-		baseFilter: p.Base.toFilter(),
+func (p *partitionedGobs) toFilter() (*PartitionedFilter, error) {
+	if p == nil || p.Base == nil {
+		return nil, invalid("Base", "is missing")
+	}
+	base, err := p.Base.toFilter()
+	if err != nil {
+		return nil, err
+	}
+	pf := &PartitionedFilter{
+		baseFilter: base,
 		maxN:       p.MaxN,
 		p:          p.P,
 	}
+	err = pf.validate()
+	if err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// GetFalsePositiveIncidence gets the incidence of false positive
+func (p *PartitionedFilter) GetFalsePositiveIncidence() float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return math.Pow(1-math.Exp(-float64(p.n)/float64(p.s)), float64(p.k))
+}
+
+// Merge sets all elements of other partitioned filter into filter
+func (p *PartitionedFilter) Merge(other Filter) error {
+	o, ok := other.(*PartitionedFilter)
+	if !ok {
+		return typeMismatch(p, other)
+	}
+	return p.UnionInPlace(o)
+}
+
+// clone copies filter with a snapshot of its base
+func (p *PartitionedFilter) clone() *PartitionedFilter {
+	return &PartitionedFilter{
+		baseFilter: p.snapshot(),
+		maxN:       p.maxN,
+		p:          p.p,
+		seed:       p.seed,
+		level:      p.level,
+	}
+}
+
+// Union creates a new partitioned filter which has elements of both filters
+func (p *PartitionedFilter) Union(other *PartitionedFilter) (*PartitionedFilter, error) {
+	u := p.clone()
+	err := u.UnionInPlace(other)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UnionInPlace sets all elements of other into filter.
+// The number of elements is re-estimated from the fill ratio.
+func (p *PartitionedFilter) UnionInPlace(other *PartitionedFilter) error {
+	return p.merge(other.baseFilter)
+}
+
+// Intersect creates a new partitioned filter which has elements common to both filters
+func (p *PartitionedFilter) Intersect(other *PartitionedFilter) (*PartitionedFilter, error) {
+	i := p.clone()
+	err := i.IntersectInPlace(other)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// IntersectInPlace drops elements of filter which other does not have.
+// The number of elements is re-estimated from the fill ratio.
+func (p *PartitionedFilter) IntersectInPlace(other *PartitionedFilter) error {
+	return p.intersect(other.baseFilter)
 }
 
 // PartitionedFilters is slice of PartitionedFilter
@@ -54,6 +137,41 @@ func (ps PartitionedFilters) Last() *PartitionedFilter {
 	return ps[len(ps)-1]
 }
 
+// byLevel groups filters by their levels, which are in order
+func (ps PartitionedFilters) byLevel() []PartitionedFilters {
+	var groups []PartitionedFilters
+	for _, pf := range ps {
+		for pf.level >= len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[pf.level] = append(groups[pf.level], pf)
+	}
+	return groups
+}
+
+// union combines pf with a filter of the same geometry and seed in ps
+// as long as the union stays within its max number of elements, otherwise pf is appended
+func (ps PartitionedFilters) union(pf *PartitionedFilter) (PartitionedFilters, error) {
+	for i, target := range ps {
+		if target.seed != pf.seed || target.checkCompatible(pf.baseFilter) != nil {
+			continue
+		}
+		u, err := target.Union(pf)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case u.bits.(bitSet).count() == target.bits.(bitSet).count():
+			// pf has nothing target does not have
+			return ps, nil
+		case u.n <= u.maxN:
+			ps[i] = u
+			return ps, nil
+		}
+	}
+	return append(ps, pf), nil
+}
+
 func (ps PartitionedFilters) toGobs() []*partitionedGobs {
 	pgs := make([]*partitionedGobs, len(ps))
 	for i := range ps {
@@ -62,16 +180,30 @@ func (ps PartitionedFilters) toGobs() []*partitionedGobs {
 	return pgs
 }
 
-func (pgs partitionedGobsSet) toFilters() PartitionedFilters {
+func (ps PartitionedFilters) seeds() []uint64 {
+	seeds := make([]uint64, len(ps))
+	for i := range ps {
+		seeds[i] = ps[i].seed
+	}
+	return seeds
+}
+
+func (pgs partitionedGobsSet) toFilters() (PartitionedFilters, error) {
 	ps := make(PartitionedFilters, len(pgs))
 	for i := range pgs {
-		ps[i] = pgs[i].toFilter()
+		var err error
+		ps[i], err = pgs[i].toFilter()
+		if err != nil {
+			return nil, err
+		}
 	}
-	return ps
+	return ps, nil
 }
 
 // GobEncode encodes data to gobs stream
 func (p *PartitionedFilter) GobEncode() ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	data := p.toGobs()
 	return gobEncode(data)
 }
@@ -84,14 +216,39 @@ func (p *PartitionedFilter) GobDecode(data []byte) error {
 		return err
 	}
 
-	p.baseFilter = pg.Base.toFilter()
-	p.maxN = pg.MaxN
-	p.p = pg.P
+	pf, err := pg.toFilter()
+	if err != nil {
+		return err
+	}
+	return p.load(pf)
+}
+
+// load replaces state of filter with decoded one under lock of its base,
+// which takes the key of filter if it is keyed
+func (p *PartitionedFilter) load(src *PartitionedFilter) error {
+	if p.baseFilter == nil {
+		err := setBase(&p.baseFilter, src.baseFilter)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		err := p.replace(src.baseFilter)
+		if err != nil {
+			return err
+		}
+	}
+	p.maxN = src.maxN
+	p.p = src.p
+	p.seed = src.seed
 	return nil
 }
 
-// ScalableFilter is implementation of scalsble bloomfilter
+// ScalableFilter is implementation of scalsble bloomfilter.
+// It is safe for concurrent use.
 type ScalableFilter struct {
+	mu      sync.RWMutex
 	filters PartitionedFilters
 	// Number of hash functions as origin
 	k int
@@ -107,6 +264,12 @@ type ScalableFilter struct {
 	growthRate int
 	// Reduction rate of false positive incidence
 	fpReduction float64
+	// Hash function for all filters
+	hasher Hasher
+	// Index strategy for all filters
+	strategy IndexStrategy
+	// Seed to derive seeds of filters
+	seed uint64
 }
 
 type scalableGobs struct {
@@ -118,15 +281,35 @@ type scalableGobs struct {
 	P           float64
 	GrowthRate  int
 	FpReduction float64
+	Hasher      string
+	KeyCheck    uint64
+	Strategy    string
+	Seed        uint64
+	// Seeds of digest for every filter
+	Seeds []uint64
 }
 
-// NewScalableFilter creates a new scalable bloomfilter instance
-func NewScalableFilter(filterSize, growthRate int, expectedFP, fpReduction float64) *ScalableFilter {
+// NewScalableFilter creates a new scalable bloomfilter instance.
+// Growth rate must be within [1, 16] for filter to be decoded.
+//
+// Unlike other filters, its filters use SeededHashing by default, which keeps
+// the compound incidence of false positive under expectedFP/(1-fpReduction).
+// Other strategies derive indices of k partitions of size s from a few hashes,
+// so an element shares every index with an added one in probability of 1/s^2,
+// or 2/s^2 for DoubleHashing with even s and 1/s^3 for TripleHashing.
+// Their bound is expectedFP/(1-fpReduction) plus n/s^2 (or the like) of every filter,
+// which is significant where partitions are small against expectedFP.
+func NewScalableFilter(filterSize, growthRate int, expectedFP, fpReduction float64, opts ...Option) *ScalableFilter {
+	o := newOptions(append([]Option{WithIndexStrategy(SeededHashing)}, opts...)...)
+	filterSize, growthRate, expectedFP, fpReduction = clampScalableParams(filterSize, growthRate, expectedFP, fpReduction)
 	sf := &ScalableFilter{
 		m:           filterSize,
 		p:           expectedFP,
 		growthRate:  growthRate,
 		fpReduction: fpReduction,
+		hasher:      o.hasher,
+		strategy:    o.strategy,
+		seed:        o.seed,
 	}
 
 	// Set origin expected false positive instance
@@ -137,16 +320,57 @@ func NewScalableFilter(filterSize, growthRate int, expectedFP, fpReduction float
 	return sf
 }
 
-// addFilter append a new filter
+// clampScalableParams gets filter size of a slot at least, growth rate within [1, 16],
+// and probabilities within (0, 1], so that every filter built is decoded from its own encoding
+func clampScalableParams(filterSize, growthRate int, expectedFP, fpReduction float64) (int, int, float64, float64) {
+	if filterSize < 1 {
+		filterSize = 1
+	}
+	switch {
+	case growthRate < 1:
+		growthRate = 1
+	case growthRate > maxGrowthRate:
+		growthRate = maxGrowthRate
+	}
+	return filterSize, growthRate, clampProbability(expectedFP), clampProbability(fpReduction)
+}
+
+// minProbability is the smallest normal float64, whose inverse is finite
+const minProbability = 2.2250738585072014e-308
+
+// clampProbability gets p within (0, 1]
+func clampProbability(p float64) float64 {
+	switch {
+	case math.IsNaN(p) || p < minProbability:
+		return minProbability
+	case p > 1:
+		return 1
+	}
+	return p
+}
+
+// stageSeed gets seed of digest for i-th filter from seed of scalable filter
+// so that every filter derives independent indices from one digest
+func stageSeed(seed uint64, i int) uint64 {
+	return mix64(seed + uint64(i+1)*0x9e3779b97f4a7c15)
+}
+
+// addFilter append a new filter of the level next to the last filter
 func (sf *ScalableFilter) addFilter() {
+	level := 0
+	if len(sf.filters) > 0 {
+		level = sf.filters.Last().level + 1
+	}
 	// Filters growth number
-	growthNum := float64(len(sf.filters))
+	growthNum := float64(level)
 	filterSize := sf.m * int(math.Pow(float64(sf.growthRate), growthNum))
-	expectedFP := sf.p * math.Pow(sf.fpReduction, float64(len(sf.filters)))
+	expectedFP := sf.p * math.Pow(sf.fpReduction, growthNum)
 	hasherNumber := sf.k + int(growthNum*math.Log2(1/sf.fpReduction)+1)
-	pf := NewPartitionedFilter(filterSize, hasherNumber)
+	pf := NewPartitionedFilter(filterSize, hasherNumber, WithHasher(sf.hasher), WithIndexStrategy(sf.strategy))
 	pf.maxN = GetBestElementNumber(filterSize, expectedFP)
 	pf.p = expectedFP
+	pf.seed = stageSeed(sf.seed, level)
+	pf.level = level
 	sf.filters = append(sf.filters, pf)
 }
 
@@ -154,25 +378,174 @@ func (sf *ScalableFilter) addFilter() {
 // In case false positive incidence is bigger than expected,
 // create a new filter and set element into it.
 func (sf *ScalableFilter) Add(element []byte) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.addHash(HashWith(sf.hasher, element))
+}
+
+// AddHash adds a new element into filter by its digest.
+// Digest must be computed with the same hasher as filter.
+func (sf *ScalableFilter) AddHash(d Digest) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.addHash(d)
+}
+
+// addHash adds a new element by its digest while holding lock
+func (sf *ScalableFilter) addHash(d Digest) {
 	if sf.filters.Last().n >= sf.filters.Last().maxN {
 		sf.addFilter()
 	}
 
-	sf.filters.Last().Add(element)
+	sf.filters.Last().AddHash(d.seed(sf.filters.Last().seed))
 
 	sf.n++
 }
 
 // Has checks whether a element already exists in all filters
 func (sf *ScalableFilter) Has(element []byte) bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.hasHash(HashWith(sf.hasher, element))
+}
+
+// HasHash checks whether a element already exists in all filters by its digest.
+// Digest must be computed with the same hasher as filter.
+func (sf *ScalableFilter) HasHash(d Digest) bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.hasHash(d)
+}
+
+// hasHash checks a element by its digest while holding lock
+func (sf *ScalableFilter) hasHash(d Digest) bool {
 	for i := len(sf.filters) - 1; i >= 0; i-- {
-		if sf.filters[i].Has(element) {
+		if sf.filters[i].HasHash(d.seed(sf.filters[i].seed)) {
 			return true
 		}
 	}
 	return false
 }
 
+// GetFalsePositiveIncidence gets the compound incidence of false positive
+// over all filters
+func (sf *ScalableFilter) GetFalsePositiveIncidence() float64 {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	notFP := 1.0
+	for i := range sf.filters {
+		notFP *= 1 - sf.filters[i].GetFalsePositiveIncidence()
+	}
+	return 1 - notFP
+}
+
+// Count gets the number of added elements in all filters
+func (sf *ScalableFilter) Count() int64 {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.n
+}
+
+// snapshot copies filter and all its filters while holding lock,
+// so that it is combined with another filter as baseFilter.combine does
+func (sf *ScalableFilter) snapshot() *ScalableFilter {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	filters := make(PartitionedFilters, len(sf.filters))
+	for i := range sf.filters {
+		filters[i] = sf.filters[i].clone()
+	}
+	return &ScalableFilter{
+		filters:     filters,
+		k:           sf.k,
+		m:           sf.m,
+		n:           sf.n,
+		maxN:        sf.maxN,
+		p:           sf.p,
+		growthRate:  sf.growthRate,
+		fpReduction: sf.fpReduction,
+		hasher:      sf.hasher,
+		strategy:    sf.strategy,
+		seed:        sf.seed,
+	}
+}
+
+// checkCompatible checks if other grows filters in the same way as filter
+func (sf *ScalableFilter) checkCompatible(other *ScalableFilter) error {
+	switch {
+	case sf.m != other.m:
+		return &IncompatibleError{Parameter: "m", Value: sf.m, Other: other.m}
+	case sf.p != other.p:
+		return &IncompatibleError{Parameter: "p", Value: sf.p, Other: other.p}
+	case sf.growthRate != other.growthRate:
+		return &IncompatibleError{Parameter: "growthRate", Value: sf.growthRate, Other: other.growthRate}
+	case sf.fpReduction != other.fpReduction:
+		return &IncompatibleError{Parameter: "fpReduction", Value: sf.fpReduction, Other: other.fpReduction}
+	case sf.hasher.ID() != other.hasher.ID():
+		return &IncompatibleError{Parameter: "hasher", Value: sf.hasher.ID(), Other: other.hasher.ID()}
+	case keyCheck(sf.hasher) != keyCheck(other.hasher):
+		return &IncompatibleError{Parameter: "key", Value: keyCheck(sf.hasher), Other: keyCheck(other.hasher)}
+	case sf.strategy.ID() != other.strategy.ID():
+		return &IncompatibleError{Parameter: "strategy", Value: sf.strategy.ID(), Other: other.strategy.ID()}
+	case sf.seed != other.seed:
+		return &IncompatibleError{Parameter: "seed", Value: sf.seed, Other: other.seed}
+	}
+	return nil
+}
+
+// Merge sets all elements of other scalable filter into filter.
+// Other must be built with the same parameters and seed, so that filters of a level are alike.
+// Every filter keeps its level, which its size, hash functions, bound and seed are of.
+// A filter of other is combined with a filter of its level
+// as long as the union stays within its max number of elements,
+// otherwise it is put after filters of its level to keep its own bound of false positive.
+// Merging a filter again adds nothing. New filters are added at the level next to the last one.
+// The compound bound of false positive is the sum of bounds of all filters,
+// which is c*p/(1-fpReduction) when a level holds c filters at most,
+// e.g. 2*p/(1-fpReduction) after merging two filters.
+func (sf *ScalableFilter) Merge(other Filter) error {
+	o, ok := other.(*ScalableFilter)
+	if !ok {
+		return typeMismatch(sf, other)
+	}
+	if sf == o {
+		return nil
+	}
+	o = o.snapshot()
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	err := sf.checkCompatible(o)
+	if err != nil {
+		return err
+	}
+
+	levels := sf.filters.byLevel()
+	for level, pfs := range o.filters.byLevel() {
+		if level == len(levels) {
+			levels = append(levels, nil)
+		}
+		for _, pf := range pfs {
+			levels[level], err = levels[level].union(pf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var filters PartitionedFilters
+	var n int64
+	for _, pfs := range levels {
+		for _, pf := range pfs {
+			filters = append(filters, pf)
+			n += int64(pf.n)
+		}
+	}
+	sf.filters = filters
+	sf.n = n
+	return nil
+}
+
 func (sf *ScalableFilter) toGobs() *scalableGobs {
 	return &scalableGobs{
 		Filters:     sf.filters.toGobs(),
@@ -183,11 +556,18 @@ func (sf *ScalableFilter) toGobs() *scalableGobs {
 		P:           sf.p,
 		GrowthRate:  sf.growthRate,
 		FpReduction: sf.fpReduction,
+		Hasher:      sf.hasher.ID(),
+		KeyCheck:    keyCheck(sf.hasher),
+		Strategy:    sf.strategy.ID(),
+		Seed:        sf.seed,
+		Seeds:       sf.filters.seeds(),
 	}
 }
 
 // GobEncode encodes data to gob stream
 func (sf *ScalableFilter) GobEncode() ([]byte, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
 	data := sf.toGobs()
 	return gobEncode(data)
 }
@@ -200,14 +580,68 @@ func (sf *ScalableFilter) GobDecode(data []byte) error {
 		return err
 	}
 
-	sf.filters = sg.Filters.toFilters()
-	sf.k = sg.K
-	sf.m = sg.M
-	sf.n = sg.N
-	sf.maxN = sg.MaxN
-	sf.p = sg.P
-	sf.growthRate = sg.GrowthRate
-	sf.fpReduction = sg.FpReduction
+	hasher, err := lookupHasher(sg.Hasher)
+	if err != nil {
+		return err
+	}
+	strategy, err := lookupIndexStrategy(sg.Strategy)
+	if err != nil {
+		return err
+	}
+	filters, err := sg.Filters.toFilters()
+	if err != nil {
+		return err
+	}
+	// Streams encoded before seeds were recorded have no seed
+	if sg.Seeds != nil && len(sg.Seeds) != len(filters) {
+		return invalid("Seeds", "must be as many as filters %d, but %d", len(filters), len(sg.Seeds))
+	}
+	for i := range sg.Seeds {
+		filters[i].seed = sg.Seeds[i]
+	}
+
+	d := &ScalableFilter{
+		filters:     filters,
+		hasher:      withKeyCheck(hasher, sg.KeyCheck),
+		strategy:    strategy,
+		seed:        sg.Seed,
+		k:           sg.K,
+		m:           sg.M,
+		n:           sg.N,
+		maxN:        sg.MaxN,
+		p:           sg.P,
+		growthRate:  sg.GrowthRate,
+		fpReduction: sg.FpReduction,
+	}
+	err = d.validate()
+	if err != nil {
+		return err
+	}
+	return sf.load(d)
+}
 
+// load replaces state of filter with decoded one,
+// which takes the key of filter if it is keyed
+func (sf *ScalableFilter) load(src *ScalableFilter) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	hasher, err := withKeyOf(src.hasher, sf.hasher)
+	if err != nil {
+		return err
+	}
+	for _, pf := range src.filters {
+		pf.hasher = hasher
+	}
+	sf.filters = src.filters
+	sf.hasher = hasher
+	sf.strategy = src.strategy
+	sf.seed = src.seed
+	sf.k = src.k
+	sf.m = src.m
+	sf.n = src.n
+	sf.maxN = src.maxN
+	sf.p = src.p
+	sf.growthRate = src.growthRate
+	sf.fpReduction = src.fpReduction
 	return nil
 }
@@ -1,7 +1,12 @@
 package blooms
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/satori/go.uuid"
 	. "github.com/smartystreets/goconvey/convey"
@@ -17,7 +22,8 @@ func TestNewPartitionedFilter(t *testing.T) {
 
 			Convey("Then created instance should be expected", func() {
 				So(b, ShouldNotBeNil)
-				So(len(b.bits), ShouldEqual, m)
+				So(b.m, ShouldEqual, m)
+				So(len(b.bits.(bitSet)), ShouldEqual, 2)
 				So(b.k, ShouldEqual, k)
 				So(b.s, ShouldEqual, int(m/k))
 
@@ -66,7 +72,7 @@ func TestPartitionedFilter_GobDecode(t *testing.T) {
 
 			Convey("Then expected bytes slice should be returned", func() {
 				So(err, ShouldBeNil)
-				So(len(res.bits), ShouldEqual, 128)
+				So(res.m, ShouldEqual, 128)
 				So(res.k, ShouldEqual, p.k)
 				So(res.s, ShouldEqual, p.s)
 				So(res.Has([]byte("test")), ShouldBeTrue)
@@ -117,8 +123,8 @@ func TestScalableFilter_Add(t *testing.T) {
 				var count int
 				previous := 0
 				current := b.filters[0].s
-				for i := range b.filters[0].bits {
-					if b.filters[0].bits[i] == 1 {
+				for i := 0; i < b.filters[0].m; i++ {
+					if b.filters[0].bits.test(i) {
 						So(i, ShouldBeBetweenOrEqual, previous, current)
 						previous = current
 						current += b.filters[0].s
@@ -151,7 +157,7 @@ func TestScalableFilter_Add(t *testing.T) {
 				So(len(b.filters), ShouldEqual, 11)
 				So(b.filters.Last().k, ShouldEqual, 17)
 				So(b.filters.Last().maxN, ShouldEqual, 5503)
-				So(len(b.filters.Last().bits), ShouldEqual, 131072)
+				So(b.filters.Last().m, ShouldEqual, 131072)
 
 			})
 		})
@@ -251,7 +257,7 @@ func TestScalableFilter_GobDecode(t *testing.T) {
 			Convey("Then expected bytes slice should be returned", func() {
 				So(err, ShouldBeNil)
 				So(len(res.filters), ShouldEqual, len(sf.filters))
-				So(len(res.filters[0].bits), ShouldEqual, len(sf.filters[0].bits))
+				So(res.filters[0].m, ShouldEqual, sf.filters[0].m)
 				So(res.m, ShouldEqual, sf.m)
 				So(res.k, ShouldEqual, sf.k)
 				So(res.n, ShouldEqual, sf.n)
@@ -263,3 +269,457 @@ func TestScalableFilter_GobDecode(t *testing.T) {
 		})
 	})
 }
+
+// countingHasher counts computed hashes
+type countingHasher struct {
+	Hasher
+	count int
+}
+
+func (c *countingHasher) Sum64(data []byte) uint64 {
+	c.count++
+	return c.Hasher.Sum64(data)
+}
+
+func TestScalableFilter_HasHash(t *testing.T) {
+	Convey("Given scalable bloom filter grown to several filters", t, func() {
+		hasher := &countingHasher{Hasher: FNV1aHasher}
+		sf := NewScalableFilter(128, 2, 0.01, 0.8, WithHasher(hasher))
+		for i := 0; i < 1000; i++ {
+			sf.Add([]byte{byte(i), byte(i >> 8)})
+		}
+
+		Convey("When check unset element", func() {
+			hasher.count = 0
+			check := sf.Has([]byte("not_set"))
+
+			Convey("Then element should be hashed only once", func() {
+				So(len(sf.filters), ShouldBeGreaterThan, 3)
+				So(check, ShouldBeFalse)
+				So(hasher.count, ShouldEqual, 1)
+
+			})
+		})
+
+		Convey("When comparing seeds of filters", func() {
+			seeds := map[uint64]bool{}
+			for _, pf := range sf.filters {
+				seeds[pf.seed] = true
+			}
+
+			Convey("Then every filter should have its own seed", func() {
+				So(len(seeds), ShouldEqual, len(sf.filters))
+				So(seeds[0], ShouldBeFalse)
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_GobDecode_Seeds(t *testing.T) {
+	Convey("Given scalable bloom filter grown to several filters", t, func() {
+		sf := NewScalableFilter(128, 2, 0.01, 0.8)
+		for i := 0; i < 1000; i++ {
+			sf.Add([]byte{byte(i), byte(i >> 8)})
+		}
+
+		Convey("When decoding gobs stream", func() {
+			buf, _ := sf.GobEncode()
+			res := &ScalableFilter{}
+			err := res.GobDecode(buf)
+
+			Convey("Then seeds should be restored", func() {
+				So(err, ShouldBeNil)
+				for i := range sf.filters {
+					So(res.filters[i].seed, ShouldEqual, sf.filters[i].seed)
+				}
+				for i := 0; i < 1000; i++ {
+					So(res.Has([]byte{byte(i), byte(i >> 8)}), ShouldBeTrue)
+				}
+
+			})
+		})
+
+		Convey("When decoding gobs stream without seeds", func() {
+			for _, pf := range sf.filters {
+				pf.seed = 0
+			}
+			sf.Add([]byte("unseeded"))
+			sg := sf.toGobs()
+			sg.Seeds = nil
+			buf, _ := gobEncode(sg)
+			res := &ScalableFilter{}
+			err := res.GobDecode(buf)
+
+			Convey("Then filters should be used without seed", func() {
+				So(err, ShouldBeNil)
+				So(res.filters.Last().seed, ShouldEqual, 0)
+				So(res.Has([]byte("unseeded")), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestWithSeed(t *testing.T) {
+	Convey("Given scalable bloom filters with different seeds", t, func() {
+		a := NewScalableFilter(128, 2, 0.01, 0.8, WithSeed(1))
+		b := NewScalableFilter(128, 2, 0.01, 0.8, WithSeed(2))
+
+		Convey("When comparing seeds of their filters", func() {
+			Convey("Then seeds should differ", func() {
+				So(a.filters[0].seed, ShouldNotEqual, b.filters[0].seed)
+				So(a.filters[0].seed, ShouldEqual, stageSeed(1, 0))
+
+			})
+		})
+
+		Convey("When decoding gobs stream and growing filter", func() {
+			buf, _ := a.GobEncode()
+			res := &ScalableFilter{}
+			err := res.GobDecode(buf)
+			for i := 0; len(res.filters) < 2; i++ {
+				res.Add([]byte{byte(i), byte(i >> 8)})
+			}
+
+			Convey("Then new filter should derive its seed from the restored one", func() {
+				So(err, ShouldBeNil)
+				So(res.seed, ShouldEqual, 1)
+				So(res.filters[1].seed, ShouldEqual, stageSeed(1, 1))
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_CompoundFalsePositive(t *testing.T) {
+	Convey("Given scalable bloom filters of every strategy grown to many filters", t, func() {
+		p := 0.01
+		n := 10000
+		queries := 20000
+		seeds := 8
+		strategies := []IndexStrategy{SeededHashing, TripleHashing, EnhancedDoubleHashing, DoubleHashing}
+
+		Convey("When querying elements never added to filters of several seeds", func() {
+			type result struct {
+				filters int
+				mean    float64
+				// Standard error of mean
+				se    float64
+				bound float64
+			}
+			// Bound of every strategy is p/(1-reduction) plus probability of sharing
+			// every index with an added element in every filter, as documented
+			bound := func(sf *ScalableFilter, reduction float64) float64 {
+				b := p / (1 - reduction)
+				for _, pf := range sf.filters {
+					b += float64(pf.n) / indexTuples(pf.strategy, pf.s, pf.k)
+				}
+				return b
+			}
+			var results []result
+			for _, s := range strategies {
+				for _, reduction := range []float64{0.5, 0.8, 0.9} {
+					var r result
+					rates := make([]float64, seeds)
+					for seed := range rates {
+						sf := NewScalableFilter(1024, 2, p, reduction, WithIndexStrategy(s), WithSeed(uint64(seed)))
+						for i := 0; i < n; i++ {
+							sf.Add([]byte{'a', byte(i), byte(i >> 8), byte(i >> 16)})
+						}
+						var count int
+						for i := 0; i < queries; i++ {
+							if sf.Has([]byte{'b', byte(i), byte(i >> 8), byte(i >> 16)}) {
+								count++
+							}
+						}
+						rates[seed] = float64(count) / float64(queries)
+						r.mean += rates[seed] / float64(seeds)
+						r.bound += bound(sf, reduction) / float64(seeds)
+						r.filters = len(sf.filters)
+					}
+					for _, rate := range rates {
+						r.se += (rate - r.mean) * (rate - r.mean) / float64(seeds-1)
+					}
+					r.se = math.Sqrt(r.se / float64(seeds))
+					results = append(results, r)
+				}
+			}
+
+			// Bounds hold for the expected rate over filters of random seeds,
+			// so the mean rate must not exceed it by more than its sampling error
+			Convey("Then mean false positive rate should be under compound bound of every strategy", func() {
+				for _, r := range results {
+					So(r.filters, ShouldBeGreaterThan, 5)
+					So(r.mean-3*r.se, ShouldBeLessThan, r.bound)
+				}
+
+			})
+		})
+	})
+}
+
+// indexTuples gets the number of distinct tuples of indices which strategy derives
+// for k partitions of size, as documented in NewScalableFilter
+func indexTuples(strategy IndexStrategy, size, k int) float64 {
+	s := float64(size)
+	switch strategy.(type) {
+	case seededHashing:
+		return math.Pow(s, float64(k))
+	case tripleHashing:
+		return s * s * s
+	case doubleHashing:
+		if size%2 == 0 {
+			return s * s / 2
+		}
+	}
+	return s * s
+}
+
+func TestScalableFilter_Merge(t *testing.T) {
+	Convey("Given scalable bloom filters of two services", t, func() {
+		p := 0.01
+		reduction := 0.8
+		a := NewScalableFilter(1024, 2, p, reduction)
+		b := NewScalableFilter(1024, 2, p, reduction)
+		for i := 0; i < 5000; i++ {
+			a.Add([]byte{'a', byte(i), byte(i >> 8)})
+			b.Add([]byte{'b', byte(i), byte(i >> 8)})
+		}
+
+		Convey("When merging one into the other", func() {
+			err := a.Merge(b)
+
+			Convey("Then every element should exist", func() {
+				So(err, ShouldBeNil)
+				for i := 0; i < 5000; i++ {
+					So(a.Has([]byte{'a', byte(i), byte(i >> 8)}), ShouldBeTrue)
+					So(a.Has([]byte{'b', byte(i), byte(i >> 8)}), ShouldBeTrue)
+				}
+
+			})
+
+			Convey("Then the number of elements should be sum of its filters", func() {
+				var n int64
+				for _, pf := range a.filters {
+					n += int64(pf.n)
+					So(pf.n, ShouldBeLessThanOrEqualTo, pf.maxN)
+				}
+				So(a.Count(), ShouldEqual, n)
+				So(a.Count(), ShouldAlmostEqual, 10000, 500)
+
+			})
+
+			Convey("Then filters should stay in order of levels and grow from the last one", func() {
+				for i, pf := range a.filters {
+					So(pf.m, ShouldEqual, 1024<<uint(pf.level))
+					So(pf.seed, ShouldEqual, stageSeed(0, pf.level))
+					if i > 0 {
+						So(pf.level-a.filters[i-1].level, ShouldBeBetweenOrEqual, 0, 1)
+					}
+				}
+				last := a.filters.Last().level
+				for i := 0; a.filters.Last().level == last; i++ {
+					a.Add([]byte{'d', byte(i), byte(i >> 8)})
+				}
+				So(a.filters.Last().m, ShouldEqual, 1024<<uint(last+1))
+				So(a.filters.Last().seed, ShouldEqual, stageSeed(0, last+1))
+
+			})
+
+			Convey("Then false positive rate should stay under doubled compound bound", func() {
+				var count int
+				queries := 100000
+				for i := 0; i < queries; i++ {
+					if a.Has([]byte{'c', byte(i), byte(i >> 8), byte(i >> 16)}) {
+						count++
+					}
+				}
+				So(float64(count)/float64(queries), ShouldBeLessThan, 2*p/(1-reduction))
+
+			})
+		})
+
+		Convey("When merging filter holding the same elements", func() {
+			c := NewScalableFilter(1024, 2, p, reduction)
+			for i := 0; i < 5000; i++ {
+				c.Add([]byte{'a', byte(i), byte(i >> 8)})
+			}
+			stages := len(a.filters)
+			err := a.Merge(c)
+
+			Convey("Then filters should be combined instead of appended", func() {
+				So(err, ShouldBeNil)
+				So(len(a.filters), ShouldEqual, stages)
+				So(a.Count(), ShouldAlmostEqual, 5000, 250)
+
+			})
+		})
+
+		Convey("When merging filters growing by 1 repeatedly", func() {
+			g := NewScalableFilter(1024, 1, p, reduction)
+			h := NewScalableFilter(1024, 1, p, reduction)
+			for i := 0; i < 500; i++ {
+				g.Add([]byte{'g', byte(i), byte(i >> 8)})
+				h.Add([]byte{'h', byte(i), byte(i >> 8)})
+			}
+			err := g.Merge(h)
+			stages := len(g.filters)
+			for i := 0; i < 3; i++ {
+				g.Merge(h)
+			}
+			last := g.filters.Last().level
+			for i := 0; g.filters.Last().level == last; i++ {
+				g.Add([]byte{'d', byte(i), byte(i >> 8)})
+			}
+			buf, _ := g.GobEncode()
+			res := &ScalableFilter{}
+			derr := res.GobDecode(buf)
+
+			Convey("Then filters should be merged once and stay in order of levels", func() {
+				So(err, ShouldBeNil)
+				So(len(g.filters), ShouldEqual, stages+1)
+				var bound float64
+				for i, pf := range g.filters {
+					bound += pf.p
+					So(pf.m, ShouldEqual, 1024)
+					So(pf.seed, ShouldEqual, stageSeed(0, pf.level))
+					So(pf.p, ShouldEqual, p*math.Pow(reduction, float64(pf.level)))
+					if i > 0 {
+						So(pf.level-g.filters[i-1].level, ShouldBeBetweenOrEqual, 0, 1)
+						So(pf.k, ShouldBeGreaterThanOrEqualTo, g.filters[i-1].k)
+					}
+				}
+				So(g.filters.Last().level, ShouldEqual, last+1)
+				So(g.filters.Last().n, ShouldEqual, 1)
+
+				var count int
+				queries := 100000
+				for i := 0; i < queries; i++ {
+					if g.Has([]byte{'c', byte(i), byte(i >> 8), byte(i >> 16)}) {
+						count++
+					}
+				}
+				So(float64(count)/float64(queries), ShouldBeLessThan, bound)
+
+				So(derr, ShouldBeNil)
+				for i, pf := range res.filters {
+					So(pf.level, ShouldEqual, g.filters[i].level)
+				}
+
+			})
+		})
+
+		Convey("When merging filter with another seed", func() {
+			c := NewScalableFilter(1024, 2, p, reduction, WithSeed(1))
+			c.Add([]byte("c"))
+			stages := len(a.filters)
+			err := a.Merge(c)
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "seed", Value: uint64(0), Other: uint64(1)})
+				So(len(a.filters), ShouldEqual, stages)
+
+			})
+		})
+
+		Convey("When merging filter with different base parameters", func() {
+			err := a.Merge(NewScalableFilter(1024, 2, 0.001, reduction))
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "p", Value: p, Other: 0.001})
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_Concurrent(t *testing.T) {
+	Convey("Given scalable bloom filter", t, func() {
+		sf := NewScalableFilter(128, 2, 0.01, 0.8)
+		workers := 8
+		perWorker := 500
+
+		Convey("When adding, checking and encoding concurrently", func() {
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(3)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						sf.Add([]byte{byte(w), byte(i), byte(i >> 8)})
+					}
+				}(w)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						sf.Has([]byte{byte(w), byte(i), byte(i >> 8)})
+					}
+				}(w)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 10; i++ {
+						sf.GobEncode()
+						sf.WriteTo(ioutil.Discard)
+						sf.GetFalsePositiveIncidence()
+					}
+				}()
+			}
+			wg.Wait()
+
+			expected := NewScalableFilter(128, 2, 0.01, 0.8)
+			for i := 0; i < workers*perWorker; i++ {
+				expected.Add([]byte{byte(i)})
+			}
+
+			Convey("Then every element should exist and filters should grow exactly once each", func() {
+				So(sf.Count(), ShouldEqual, workers*perWorker)
+				So(len(sf.filters), ShouldEqual, len(expected.filters))
+				for w := 0; w < workers; w++ {
+					for i := 0; i < perWorker; i++ {
+						So(sf.Has([]byte{byte(w), byte(i), byte(i >> 8)}), ShouldBeTrue)
+					}
+				}
+
+			})
+		})
+
+		Convey("When checking while another reader holds the filter", func() {
+			sf.mu.RLock()
+			done := make(chan bool)
+			go func() {
+				done <- sf.Has([]byte("test"))
+			}()
+
+			var blocked bool
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				blocked = true
+			}
+			sf.mu.RUnlock()
+
+			Convey("Then reader should not be blocked", func() {
+				So(blocked, ShouldBeFalse)
+
+			})
+		})
+	})
+}
+
+func BenchmarkScalableFilter_Has(b *testing.B) {
+	for _, stages := range []int{1, 4, 8, 16} {
+		sf := NewScalableFilter(1024, 2, 0.001, 0.8)
+		for i := 0; len(sf.filters) < stages; i++ {
+			sf.Add([]byte{byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24)})
+		}
+		e := []byte("not_set")
+
+		b.Run(fmt.Sprintf("stages=%d", stages), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sf.Has(e)
+			}
+		})
+	}
+}
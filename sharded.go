@@ -0,0 +1,312 @@
+package blooms
+
+import (
+	"errors"
+	"sync"
+)
+
+// ShardedFilter is a set of independent bloomfilters.
+// Each element is routed to one of shards by its hash, and every shard has
+// its own lock, so writers contend only within a shard.
+type ShardedFilter struct {
+	shardedBase
+}
+
+// ShardedCountingFilter is a set of independent counting filters,
+// which removes elements unlike ShardedFilter
+type ShardedCountingFilter struct {
+	shardedBase
+}
+
+// shardedBase is the body of sharded filters
+type shardedBase struct {
+	// mu guards shards, which decoding replaces, and hashers of shards,
+	// so that elements are hashed with the same key in every shard
+	mu     sync.RWMutex
+	shards []*baseFilter
+	// Whether shards are counting filters
+	counting bool
+}
+
+type shardedGobs struct {
+	Shards   []*baseGobs
+	Counting bool
+}
+
+// errNoShard is the panic of constructors of sharded filter without shard
+var errNoShard = errors.New("blooms: sharded filter must have a shard at least")
+
+// NewShardedFilter creates a new sharded bloomfilter instance
+// which has shardNumber bloomfilters of filterSize.
+// It panics if shardNumber is less than 1, as decoding rejects such filter.
+func NewShardedFilter(shardNumber, filterSize, hasherNumber int, opts ...Option) *ShardedFilter {
+	if shardNumber < 1 {
+		panic(errNoShard)
+	}
+	sf := &ShardedFilter{}
+	sf.shards = make([]*baseFilter, shardNumber)
+	for i := range sf.shards {
+		sf.shards[i] = New(filterSize, hasherNumber, opts...).baseFilter
+	}
+	return sf
+}
+
+// NewShardedCountingFilter creates a new sharded counting filter instance
+// which has shardNumber counting filters of filterSize.
+// It panics if shardNumber is less than 1, as decoding rejects such filter.
+func NewShardedCountingFilter(shardNumber, filterSize, hasherNumber int, opts ...Option) *ShardedCountingFilter {
+	if shardNumber < 1 {
+		panic(errNoShard)
+	}
+	sf := &ShardedCountingFilter{}
+	sf.shards = make([]*baseFilter, shardNumber)
+	sf.counting = true
+	for i := range sf.shards {
+		sf.shards[i] = NewCountingFilter(filterSize, hasherNumber, opts...).baseFilter
+	}
+	return sf
+}
+
+// shard gets the shard which element of d belongs to
+func (sf *shardedBase) shard(d Digest) *baseFilter {
+	return sf.shards[mix64(d.h1+d.h2)%uint64(len(sf.shards))]
+}
+
+// Add adds a new element into its shard
+func (sf *shardedBase) Add(element []byte) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	sf.addHash(sf.shards[0].createHash(element))
+}
+
+// AddHash adds a new element into its shard by its digest.
+// Digest must be computed with the same hasher as filter.
+func (sf *shardedBase) AddHash(d Digest) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	sf.addHash(d)
+}
+
+// addHash adds a new element into its shard while holding lock of shards
+func (sf *shardedBase) addHash(d Digest) {
+	s := sf.shard(d)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addHash(d)
+}
+
+// Has checks if a element already exists in its shard
+func (sf *shardedBase) Has(element []byte) bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.hasHash(sf.shards[0].createHash(element))
+}
+
+// HasHash checks if a element already exists in its shard by its digest.
+// Digest must be computed with the same hasher as filter.
+func (sf *shardedBase) HasHash(d Digest) bool {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	return sf.hasHash(d)
+}
+
+// hasHash checks if a element already exists in its shard while holding lock of shards
+func (sf *shardedBase) hasHash(d Digest) bool {
+	s := sf.shard(d)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hasHash(d)
+}
+
+// Remove removes a element from its shard
+func (sf *ShardedCountingFilter) Remove(element []byte) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	sf.removeHash(sf.shards[0].createHash(element))
+}
+
+// RemoveHash removes a element from its shard by its digest.
+// Digest must be computed with the same hasher as filter.
+func (sf *ShardedCountingFilter) RemoveHash(d Digest) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	sf.removeHash(d)
+}
+
+// removeHash removes a element from its shard while holding lock of shards
+func (sf *ShardedCountingFilter) removeHash(d Digest) {
+	s := sf.shard(d)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeHash(d)
+}
+
+// Count gets the number of added elements in all shards
+func (sf *shardedBase) Count() int64 {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	var n int64
+	for _, s := range sf.shards {
+		s.mu.RLock()
+		n += int64(s.n)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// GetFalsePositiveIncidence gets the incidence of false positive.
+// Elements are routed to shards uniformly,
+// so it is the average over all shards.
+func (sf *shardedBase) GetFalsePositiveIncidence() float64 {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	var fp float64
+	for _, s := range sf.shards {
+		s.mu.RLock()
+		fp += getFalsePositiveIncidence(s.k, s.n, s.m)
+		s.mu.RUnlock()
+	}
+	return fp / float64(len(sf.shards))
+}
+
+// Merge sets all elements of other sharded filter shard by shard
+func (sf *ShardedFilter) Merge(other Filter) error {
+	o, ok := other.(*ShardedFilter)
+	if !ok {
+		return typeMismatch(sf, other)
+	}
+	return sf.merge(&o.shardedBase)
+}
+
+// Merge adds counters of other sharded counting filter shard by shard up to 255.
+// Merging filter into itself doubles its counters.
+func (sf *ShardedCountingFilter) Merge(other Filter) error {
+	o, ok := other.(*ShardedCountingFilter)
+	if !ok {
+		return typeMismatch(sf, other)
+	}
+	return sf.merge(&o.shardedBase)
+}
+
+// merge combines every shard of other into the shard of filter
+func (sf *shardedBase) merge(other *shardedBase) error {
+	o := other.snapshot()
+
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	if len(sf.shards) != len(o.shards) {
+		return &IncompatibleError{Parameter: "shards", Value: len(sf.shards), Other: len(o.shards)}
+	}
+	for i := range o.shards {
+		err := sf.shards[i].compatible(o.shards[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range sf.shards {
+		err := sf.shards[i].mergeSnapshot(o.shards[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot copies filter and all its shards while holding lock,
+// so that it is combined with another filter as baseFilter.combine does
+func (sf *shardedBase) snapshot() *shardedBase {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	shards := make([]*baseFilter, len(sf.shards))
+	for i := range sf.shards {
+		shards[i] = sf.shards[i].snapshot()
+	}
+	return &shardedBase{shards: shards, counting: sf.counting}
+}
+
+// load replaces shards of filter with decoded ones,
+// which take the key of filter if it is keyed
+func (sf *shardedBase) load(src *shardedBase) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var receiver Hasher
+	if len(sf.shards) > 0 {
+		receiver = sf.shards[0].hasher
+	}
+	hasher, err := withKeyOf(src.shards[0].hasher, receiver)
+	if err != nil {
+		return err
+	}
+	for _, s := range src.shards {
+		s.hasher = hasher
+	}
+	sf.shards = src.shards
+	sf.counting = src.counting
+	return nil
+}
+
+func (sf *shardedBase) toGobs() *shardedGobs {
+	sg := &shardedGobs{
+		Shards:   make([]*baseGobs, len(sf.shards)),
+		Counting: sf.counting,
+	}
+	for i, s := range sf.shards {
+		sg.Shards[i] = s.toGobs()
+	}
+	return sg
+}
+
+// GobEncode encodes data to gobs stream.
+// All shards are read locked while encoding to take a consistent snapshot.
+func (sf *shardedBase) GobEncode() ([]byte, error) {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+	for _, s := range sf.shards {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return gobEncode(sf.toGobs())
+}
+
+// GobDecode decodes gobs stream
+func (sf *ShardedFilter) GobDecode(data []byte) error {
+	return sf.gobDecode(data, false)
+}
+
+// GobDecode decodes gobs stream
+func (sf *ShardedCountingFilter) GobDecode(data []byte) error {
+	return sf.gobDecode(data, true)
+}
+
+// gobDecode decodes gobs stream of sharded filter whose shards are counting or not
+func (sf *shardedBase) gobDecode(data []byte, counting bool) error {
+	var sg shardedGobs
+	err := gobDecode(data, &sg)
+	if err != nil {
+		return err
+	}
+	if sg.Counting != counting {
+		return ErrFilterType
+	}
+
+	shards := make([]*baseFilter, len(sg.Shards))
+	for i, bg := range sg.Shards {
+		if bg == nil {
+			return nested("Shards", i, invalid("Base", "is missing"))
+		}
+		if counting {
+			shards[i], err = bg.toCountingFilter()
+		} else {
+			shards[i], err = bg.toFilter()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	d := &shardedBase{shards: shards, counting: counting}
+	err = d.validate()
+	if err != nil {
+		return err
+	}
+	return sf.load(d)
+}
@@ -0,0 +1,239 @@
+package blooms
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewShardedFilter(t *testing.T) {
+	Convey("Given shard number, filter size, hasher number", t, func() {
+		shards := 4
+		m := 128
+		k := 5
+
+		Convey("When creating a new sharded filter", func() {
+			sf := NewShardedFilter(shards, m, k)
+			cf := NewShardedCountingFilter(shards, m, k)
+
+			Convey("Then created instance should be expected", func() {
+				So(len(sf.shards), ShouldEqual, shards)
+				So(sf.counting, ShouldBeFalse)
+				So(sf.shards[0].m, ShouldEqual, m)
+				So(sf.shards[0].k, ShouldEqual, k)
+				So(sf.shards[0].bits, ShouldHaveSameTypeAs, bitSet{})
+				So(len(cf.shards), ShouldEqual, shards)
+				So(cf.counting, ShouldBeTrue)
+				So(cf.shards[0].bits, ShouldHaveSameTypeAs, counterSet{})
+
+			})
+		})
+
+		Convey("When creating a new sharded filter without shard", func() {
+			create := func() { NewShardedFilter(0, m, k) }
+			createCounting := func() { NewShardedCountingFilter(-1, m, k) }
+
+			Convey("Then it should be rejected", func() {
+				So(create, ShouldPanicWith, errNoShard)
+				So(createCounting, ShouldPanicWith, errNoShard)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_Add(t *testing.T) {
+	Convey("Given sharded filter", t, func() {
+		sf := NewShardedFilter(4, 1024, 5)
+
+		Convey("When adding many elements", func() {
+			for i := 0; i < 1000; i++ {
+				sf.Add([]byte{byte(i), byte(i >> 8)})
+			}
+
+			Convey("Then elements should be spread over shards", func() {
+				So(sf.Count(), ShouldEqual, 1000)
+				for _, s := range sf.shards {
+					So(s.n, ShouldBeBetween, 200, 300)
+				}
+				for i := 0; i < 1000; i++ {
+					So(sf.Has([]byte{byte(i), byte(i >> 8)}), ShouldBeTrue)
+				}
+				So(sf.GetFalsePositiveIncidence(), ShouldBeBetween, 0, 1)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_Remove(t *testing.T) {
+	Convey("Given sharded counting filter and set element", t, func() {
+		sf := NewShardedCountingFilter(4, 128, 5)
+		e := []byte("test")
+		sf.Add(e)
+
+		Convey("When removing a element", func() {
+			sf.Remove(e)
+
+			Convey("Then element should not remain", func() {
+				So(sf.Has(e), ShouldBeFalse)
+				So(sf.Count(), ShouldEqual, 0)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_Concurrent(t *testing.T) {
+	Convey("Given sharded counting filter", t, func() {
+		sf := NewShardedCountingFilter(8, 1<<14, 5)
+		workers := 16
+		perWorker := 500
+
+		Convey("When adding, checking and removing concurrently", func() {
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(2)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						sf.Add([]byte{byte(w), byte(i), byte(i >> 8)})
+						sf.Add([]byte{'r', byte(w), byte(i), byte(i >> 8)})
+						sf.Remove([]byte{'r', byte(w), byte(i), byte(i >> 8)})
+					}
+				}(w)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWorker; i++ {
+						sf.Has([]byte{byte(w), byte(i), byte(i >> 8)})
+					}
+					sf.GobEncode()
+					sf.WriteTo(ioutil.Discard)
+					sf.GetFalsePositiveIncidence()
+				}(w)
+			}
+			wg.Wait()
+
+			Convey("Then every added element should remain", func() {
+				So(sf.Count(), ShouldEqual, workers*perWorker)
+				var missing int
+				for w := 0; w < workers; w++ {
+					for i := 0; i < perWorker; i++ {
+						if !sf.Has([]byte{byte(w), byte(i), byte(i >> 8)}) {
+							missing++
+						}
+					}
+				}
+				So(missing, ShouldEqual, 0)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_Merge(t *testing.T) {
+	Convey("Given two sharded counting filters with the same shape", t, func() {
+		a := NewShardedCountingFilter(4, 128, 3)
+		b := NewShardedCountingFilter(4, 128, 3)
+		a.Add([]byte("a"))
+		b.Add([]byte("b"))
+		b.Add([]byte("a"))
+
+		Convey("When merging one into the other", func() {
+			err := a.Merge(b)
+
+			Convey("Then counters should be added up", func() {
+				So(err, ShouldBeNil)
+				So(a.Has([]byte("a")), ShouldBeTrue)
+				So(a.Has([]byte("b")), ShouldBeTrue)
+				So(a.Count(), ShouldEqual, 3)
+				a.Remove([]byte("a"))
+				So(a.Has([]byte("a")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging filter into itself", func() {
+			err := a.Merge(a)
+
+			Convey("Then counters should be doubled", func() {
+				So(err, ShouldBeNil)
+				So(a.Count(), ShouldEqual, 2)
+				a.Remove([]byte("a"))
+				So(a.Has([]byte("a")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging filters with different shard number", func() {
+			err := a.Merge(NewShardedCountingFilter(2, 128, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When merging sharded bloom filter", func() {
+			err := a.Merge(NewShardedFilter(4, 128, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_GobDecode(t *testing.T) {
+	Convey("Given sharded counting filter converted to gobs stream", t, func() {
+		sf := NewShardedCountingFilter(4, 128, 3, WithHasher(FNV1aHasher))
+		sf.Add([]byte("test"))
+
+		buf, _ := sf.GobEncode()
+
+		Convey("When decoding gobs stream", func() {
+			res := &ShardedCountingFilter{}
+			err := res.GobDecode(buf)
+
+			Convey("Then filter should be restored", func() {
+				So(err, ShouldBeNil)
+				So(len(res.shards), ShouldEqual, 4)
+				So(res.counting, ShouldBeTrue)
+				So(res.shards[0].hasher, ShouldEqual, FNV1aHasher)
+				So(res.Count(), ShouldEqual, 1)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+				res.Remove([]byte("test"))
+				So(res.Has([]byte("test")), ShouldBeFalse)
+
+			})
+		})
+
+		Convey("When decoding it as sharded bloom filter", func() {
+			data, err := sf.MarshalBinary()
+			So(err, ShouldBeNil)
+			j, err := sf.MarshalJSON()
+			So(err, ShouldBeNil)
+			res := &ShardedFilter{}
+
+			Convey("Then ErrFilterType should be returned", func() {
+				So(res.GobDecode(buf), ShouldEqual, ErrFilterType)
+				So(res.UnmarshalBinary(data), ShouldEqual, ErrFilterType)
+				So(res.UnmarshalJSON(j), ShouldEqual, ErrFilterType)
+
+			})
+		})
+	})
+}
+
+func BenchmarkShardedFilter_Add(b *testing.B) {
+	for _, goroutines := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", goroutines), func(b *testing.B) {
+			benchmarkParallelAdd(b, NewShardedCountingFilter(16, 1<<16, 7), goroutines)
+		})
+	}
+}
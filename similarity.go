@@ -0,0 +1,73 @@
+package blooms
+
+import "math"
+
+// jaccard estimates Jaccard similarity from the numbers of set bits of a, b and their union.
+// Similarity of two empty sets is 1.
+func jaccard(a, b, u int, size, k float64) float64 {
+	nu := estimateBits(float64(u), size, k).Value
+	if nu == 0 {
+		return 1
+	}
+	na := estimateBits(float64(a), size, k).Value
+	nb := estimateBits(float64(b), size, k).Value
+	return math.Min(math.Max((na+nb-nu)/nu, 0), 1)
+}
+
+// similarityBits gets bit maps of compatible snapshots
+func similarityBits(a, b *baseFilter) (bitSet, bitSet, error) {
+	err := a.checkCompatible(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	x, ok := a.bits.(bitSet)
+	y, otherOK := b.bits.(bitSet)
+	if !ok || !otherOK {
+		return nil, nil, storageMismatch(a, b)
+	}
+	return x, y, nil
+}
+
+// Similarity estimates Jaccard similarity |A∩B|/|A∪B| of elements of two compatible bloom filters
+// from the numbers of set bits of each and their union
+func Similarity(a, b *BloomFilter) (float64, error) {
+	sa, sb := a.snapshot(), b.snapshot()
+	x, y, err := similarityBits(sa, sb)
+	if err != nil {
+		return 0, err
+	}
+	return jaccard(x.count(), y.count(), x.unionCount(y), sa.estimateSize(), float64(sa.k)), nil
+}
+
+// SimilarityMatrix estimates Jaccard similarity of every pair of compatible bloom filters.
+// Element [i][j] of the result is similarity of filters[i] and filters[j].
+func SimilarityMatrix(filters []*BloomFilter) ([][]float64, error) {
+	snapshots := make([]bitSet, len(filters))
+	counts := make([]int, len(filters))
+	var first *baseFilter
+	for i, f := range filters {
+		s := f.snapshot()
+		if first == nil {
+			first = s
+		}
+		_, bits, err := similarityBits(first, s)
+		if err != nil {
+			return nil, err
+		}
+		snapshots[i] = bits
+		counts[i] = bits.count()
+	}
+
+	matrix := make([][]float64, len(filters))
+	for i := range matrix {
+		matrix[i] = make([]float64, len(filters))
+	}
+	for i := range snapshots {
+		for j := i; j < len(snapshots); j++ {
+			u := snapshots[i].unionCount(snapshots[j])
+			matrix[i][j] = jaccard(counts[i], counts[j], u, first.estimateSize(), float64(first.k))
+			matrix[j][i] = matrix[i][j]
+		}
+	}
+	return matrix, nil
+}
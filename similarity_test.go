@@ -0,0 +1,106 @@
+package blooms
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newRangeFilter creates bloom filter holding integers in [from, to)
+func newRangeFilter(from, to int) *BloomFilter {
+	b := New(1<<15, 5)
+	for i := from; i < to; i++ {
+		b.Add([]byte(fmt.Sprint(i)))
+	}
+	return b
+}
+
+// exactJaccard gets Jaccard similarity of integer ranges [a, a+n) and [b, b+n)
+func exactJaccard(a, b, n int) float64 {
+	overlap := n - (b - a)
+	if b < a {
+		overlap = n - (a - b)
+	}
+	if overlap < 0 {
+		overlap = 0
+	}
+	return float64(overlap) / float64(2*n-overlap)
+}
+
+func TestSimilarity(t *testing.T) {
+	Convey("Given bloom filters of sets overlapping variously", t, func() {
+		n := 1000
+		base := newRangeFilter(0, n)
+
+		Convey("When estimating similarity to the base set", func() {
+			for _, shift := range []int{0, 100, 300, 500, 800, 1000} {
+				other := newRangeFilter(shift, shift+n)
+				s, err := Similarity(base, other)
+
+				Convey(fmt.Sprintf("Then it should be close to exact Jaccard with shift %d", shift), func() {
+					So(err, ShouldBeNil)
+					So(s, ShouldAlmostEqual, exactJaccard(0, shift, n), 0.03)
+
+				})
+			}
+		})
+
+		Convey("When estimating similarity of empty filters", func() {
+			s, err := Similarity(New(1<<15, 5), New(1<<15, 5))
+
+			Convey("Then they should be the same", func() {
+				So(err, ShouldBeNil)
+				So(s, ShouldEqual, 1)
+
+			})
+		})
+
+		Convey("When estimating similarity to filter with different shape", func() {
+			_, err := Similarity(base, New(1<<14, 5))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestSimilarityMatrix(t *testing.T) {
+	Convey("Given bloom filters of sets for several days", t, func() {
+		n := 1000
+		starts := []int{0, 200, 500, 1200}
+		filters := make([]*BloomFilter, len(starts))
+		for i, start := range starts {
+			filters[i] = newRangeFilter(start, start+n)
+		}
+
+		Convey("When computing pairwise similarity", func() {
+			matrix, err := SimilarityMatrix(filters)
+
+			Convey("Then it should be symmetric and close to exact Jaccard", func() {
+				So(err, ShouldBeNil)
+				So(len(matrix), ShouldEqual, len(filters))
+				for i := range starts {
+					So(matrix[i][i], ShouldEqual, 1)
+					for j := range starts {
+						So(matrix[i][j], ShouldEqual, matrix[j][i])
+						So(matrix[i][j], ShouldAlmostEqual, exactJaccard(starts[i], starts[j], n), 0.03)
+					}
+				}
+
+			})
+		})
+
+		Convey("When a filter has different shape", func() {
+			_, err := SimilarityMatrix(append(filters, New(1<<15, 4)))
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &IncompatibleError{Parameter: "k", Value: 5, Other: 4})
+
+			})
+		})
+	})
+}
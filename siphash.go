@@ -0,0 +1,131 @@
+package blooms
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrKeyMismatch is returned when a key differs from the one filter was built with
+	ErrKeyMismatch = errors.New("blooms: key mismatch")
+	// ErrMissingKey is returned when decoding keyed filter into a filter without its key
+	ErrMissingKey = errors.New("blooms: keyed filter decoded without its key")
+)
+
+// keyCheckMessage is hashed to tag a key without revealing it
+var keyCheckMessage = []byte("blooms key check")
+
+// sipHasher is SipHash-2-4 keyed with 128bit secret
+type sipHasher struct {
+	k0, k1 uint64
+	// Tag of the key to verify the key of filter decoded into
+	check uint64
+}
+
+// NewSipHasher creates a keyed Hasher with SipHash-2-4.
+// The key is never encoded with filter, so it is supplied before decoding:
+// a keyed filter is decoded only into a filter built with
+// WithHasher(NewSipHasher(key)) of the same key,
+// and decoding returns ErrMissingKey or ErrKeyMismatch otherwise.
+func NewSipHasher(key [16]byte) Hasher {
+	h := &sipHasher{
+		k0: binary.LittleEndian.Uint64(key[:8]),
+		k1: binary.LittleEndian.Uint64(key[8:]),
+	}
+	h.check = h.Sum64(keyCheckMessage)
+	return h
+}
+
+func (h *sipHasher) ID() string { return "siphash-2-4" }
+
+// Sum64 computes SipHash-2-4 of data
+func (h *sipHasher) Sum64(data []byte) uint64 {
+	return sipHash24(h.k0, h.k1, data)
+}
+
+// keyCheck gets tag of key if hasher is keyed
+func keyCheck(h Hasher) uint64 {
+	if sh, ok := h.(*sipHasher); ok {
+		return sh.check
+	}
+	return 0
+}
+
+// withKeyCheck restores tag of key for hasher decoded from stream.
+// Decoded hasher has no key until withKeyOf replaces it with hasher of receiver.
+func withKeyCheck(h Hasher, check uint64) Hasher {
+	if _, ok := h.(*sipHasher); ok {
+		return &sipHasher{check: check}
+	}
+	return h
+}
+
+// withKeyOf gets hasher decoded from stream keyed with the key of hasher of receiver.
+// Keyed filter must be decoded into a filter keyed with the same key,
+// so that a decoded filter never hashes without key.
+func withKeyOf(decoded, receiver Hasher) (Hasher, error) {
+	sh, ok := decoded.(*sipHasher)
+	if !ok {
+		return decoded, nil
+	}
+	rh, ok := receiver.(*sipHasher)
+	if !ok {
+		return nil, ErrMissingKey
+	}
+	if rh.check != sh.check {
+		return nil, ErrKeyMismatch
+	}
+	return rh, nil
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = v1<<13 | v1>>51
+	v1 ^= v0
+	v0 = v0<<32 | v0>>32
+	v2 += v3
+	v3 = v3<<16 | v3>>48
+	v3 ^= v2
+	v0 += v3
+	v3 = v3<<21 | v3>>43
+	v3 ^= v0
+	v2 += v1
+	v1 = v1<<17 | v1>>47
+	v1 ^= v2
+	v2 = v2<<32 | v2>>32
+	return v0, v1, v2, v3
+}
+
+// sipHash24 computes SipHash-2-4 of data with key k0 and k1
+func sipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// Last block holds remaining bytes and length
+	m := uint64(length) << 56
+	for i := range data {
+		m |= uint64(data[i]) << (8 * uint(i))
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,170 @@
+package blooms
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testSipKey() [16]byte {
+	var key [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+func TestSipHash24(t *testing.T) {
+	Convey("Given reference key of SipHash paper", t, func() {
+		h := NewSipHasher(testSipKey())
+
+		Convey("When hashing reference messages", func() {
+			msg := make([]byte, 15)
+			for i := range msg {
+				msg[i] = byte(i)
+			}
+
+			Convey("Then reference vectors should be computed", func() {
+				So(h.Sum64(nil), ShouldEqual, uint64(0x726fdb47dd0e0e31))
+				So(h.Sum64(msg[:8]), ShouldEqual, uint64(0x93f5f5799a932462))
+				So(h.Sum64(msg), ShouldEqual, uint64(0xa129ca6149be45e5))
+
+			})
+		})
+	})
+}
+
+func TestBaseFilter_GobDecode_Keyed(t *testing.T) {
+	Convey("Given keyed bloom filter converted to gobs stream", t, func() {
+		key := testSipKey()
+		b := New(128, 3, WithHasher(NewSipHasher(key)))
+		b.Add([]byte("test"))
+
+		buf, _ := b.GobEncode()
+
+		Convey("When decoding gobs stream into filter without key", func() {
+			res := &BloomFilter{}
+			err := res.GobDecode(buf)
+			unkeyed := New(128, 3)
+			unkeyed.Add([]byte("kept"))
+			uerr := unkeyed.GobDecode(buf)
+
+			Convey("Then key should not be encoded and be required", func() {
+				So(bytes.Contains(buf, key[:8]), ShouldBeFalse)
+				So(err, ShouldEqual, ErrMissingKey)
+				So(uerr, ShouldEqual, ErrMissingKey)
+				So(unkeyed.Has([]byte("kept")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding gobs stream into filter keyed with the same key", func() {
+			res := New(1, 1, WithHasher(NewSipHasher(key)))
+			err := res.GobDecode(buf)
+
+			Convey("Then element should be found", func() {
+				So(err, ShouldBeNil)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+				So(res.Has([]byte("not_set")), ShouldBeFalse)
+
+			})
+		})
+
+		Convey("When decoding gobs stream into filter keyed with another key", func() {
+			res := New(1, 1, WithHasher(NewSipHasher([16]byte{1})))
+			err := res.GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrKeyMismatch)
+
+			})
+		})
+
+		Convey("When merging filter keyed with another key", func() {
+			err := b.Merge(New(128, 3, WithHasher(NewSipHasher([16]byte{1}))))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
+
+func TestScalableFilter_GobDecode_Keyed(t *testing.T) {
+	Convey("Given keyed scalable filter converted to gobs stream", t, func() {
+		key := testSipKey()
+		sf := NewScalableFilter(128, 2, 0.01, 0.8, WithHasher(NewSipHasher(key)))
+		for i := 0; i < 100; i++ {
+			sf.Add([]byte{byte(i)})
+		}
+
+		buf, _ := sf.GobEncode()
+
+		Convey("When decoding it into filter keyed with the same key", func() {
+			res := NewScalableFilter(128, 2, 0.01, 0.8, WithHasher(NewSipHasher(key)))
+			err := res.GobDecode(buf)
+
+			Convey("Then elements should be found in every filter", func() {
+				So(err, ShouldBeNil)
+				So(len(res.filters), ShouldBeGreaterThan, 1)
+				for i := 0; i < 100; i++ {
+					So(res.Has([]byte{byte(i)}), ShouldBeTrue)
+				}
+				res.Add([]byte("new"))
+				So(res.Has([]byte("new")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding it into filter without key", func() {
+			err := (&ScalableFilter{}).GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrMissingKey)
+
+			})
+		})
+	})
+}
+
+func TestShardedFilter_GobDecode_Keyed(t *testing.T) {
+	Convey("Given keyed sharded filter converted to gobs stream", t, func() {
+		key := testSipKey()
+		sf := NewShardedFilter(4, 128, 3, WithHasher(NewSipHasher(key)))
+		sf.Add([]byte("test"))
+
+		buf, _ := sf.GobEncode()
+
+		Convey("When decoding it into filter keyed with the same key", func() {
+			res := NewShardedFilter(1, 64, 1, WithHasher(NewSipHasher(key)))
+			err := res.GobDecode(buf)
+
+			Convey("Then element should be found", func() {
+				So(err, ShouldBeNil)
+				So(res.Has([]byte("test")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding it into filter keyed with another key", func() {
+			err := NewShardedFilter(1, 64, 1, WithHasher(NewSipHasher([16]byte{1}))).GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrKeyMismatch)
+
+			})
+		})
+
+		Convey("When decoding it into filter without key", func() {
+			err := (&ShardedFilter{}).GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrMissingKey)
+
+			})
+		})
+	})
+}
@@ -0,0 +1,143 @@
+package blooms
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownIndexStrategy is returned when a stream refers to a strategy not registered
+var ErrUnknownIndexStrategy = errors.New("blooms: unknown index strategy")
+
+// IndexStrategy derives indices of hash functions from 128bit hash of element
+type IndexStrategy interface {
+	// ID returns name identifying strategy in encoded filters
+	ID() string
+	// Index computes index of i-th hash function within size
+	Index(h1, h2 uint64, i, size int) int
+}
+
+// modularStrategy is a strategy which reduces a value independent of size modulo size.
+// Index within size/f is then index within size modulo size/f,
+// so its filter can be folded.
+type modularStrategy interface {
+	IndexStrategy
+	modular()
+}
+
+// legacyDoubleHashing is double hashing with two 32bit halves of h1.
+// Streams encoded before strategies were recorded use it.
+type legacyDoubleHashing struct{}
+
+func (legacyDoubleHashing) ID() string { return "legacy-double-32" }
+
+func (legacyDoubleHashing) modular() {}
+
+func (legacyDoubleHashing) Index(h1, h2 uint64, i, size int) int {
+	l1, l2 := divideHash(h1)
+	return int(l1+uint32(i)*l2) % size
+}
+
+// doubleHashing is Kirsch-Mitzenmacher double hashing in 64bit
+type doubleHashing struct{}
+
+func (doubleHashing) ID() string { return "double" }
+
+func (doubleHashing) modular() {}
+
+// Index computes h1 + i*h2 with h2 forced to be odd,
+// so that every probe never hits the same slot when h2 is 0
+func (doubleHashing) Index(h1, h2 uint64, i, size int) int {
+	return int((h1 + uint64(i)*(h2|1)) % uint64(size))
+}
+
+// enhancedDoubleHashing is double hashing with cubic term by Dillinger and Manolios
+type enhancedDoubleHashing struct{}
+
+func (enhancedDoubleHashing) ID() string { return "enhanced-double" }
+
+func (enhancedDoubleHashing) modular() {}
+
+func (enhancedDoubleHashing) Index(h1, h2 uint64, i, size int) int {
+	x := uint64(i)
+	return int((h1 + x*h2 + (x*x*x-x)/6) % uint64(size))
+}
+
+// tripleHashing is double hashing with quadratic term of third hash
+type tripleHashing struct{}
+
+func (tripleHashing) ID() string { return "triple" }
+
+func (tripleHashing) modular() {}
+
+func (tripleHashing) Index(h1, h2 uint64, i, size int) int {
+	x := uint64(i)
+	h3 := mix64(h1 ^ h2)
+	return int((h1 + x*h2 + x*(x-1)/2*h3) % uint64(size))
+}
+
+// seededHashing is k hash functions seeded independently.
+// They hash 128bit hash of element instead of element itself,
+// so they are independent unless 128bit hashes collide.
+type seededHashing struct{}
+
+func (seededHashing) ID() string { return "seeded" }
+
+func (seededHashing) modular() {}
+
+func (seededHashing) Index(h1, h2 uint64, i, size int) int {
+	seed := uint64(i+1) * 0x9e3779b97f4a7c15
+	return int(mix64(h1^seed^mix64(h2+seed)) % uint64(size))
+}
+
+var (
+	// DoubleHashing is plain double hashing
+	DoubleHashing IndexStrategy = doubleHashing{}
+	// EnhancedDoubleHashing is enhanced double hashing and is used by default
+	EnhancedDoubleHashing IndexStrategy = enhancedDoubleHashing{}
+	// TripleHashing is triple hashing
+	TripleHashing IndexStrategy = tripleHashing{}
+	// SeededHashing is k independent seeded hash functions
+	SeededHashing IndexStrategy = seededHashing{}
+
+	// DefaultIndexStrategy is used when no strategy is given,
+	// except by ScalableFilter whose filters use SeededHashing by default
+	DefaultIndexStrategy = EnhancedDoubleHashing
+)
+
+var strategies = struct {
+	sync.RWMutex
+	m map[string]IndexStrategy
+}{
+	m: map[string]IndexStrategy{},
+}
+
+func init() {
+	RegisterIndexStrategy(legacyDoubleHashing{})
+	RegisterIndexStrategy(DoubleHashing)
+	RegisterIndexStrategy(EnhancedDoubleHashing)
+	RegisterIndexStrategy(TripleHashing)
+	RegisterIndexStrategy(SeededHashing)
+}
+
+// RegisterIndexStrategy registers strategy so that filters encoded with it can be decoded.
+// Strategy with the same ID is replaced.
+func RegisterIndexStrategy(s IndexStrategy) {
+	strategies.Lock()
+	defer strategies.Unlock()
+	strategies.m[s.ID()] = s
+}
+
+// lookupIndexStrategy gets a registered strategy by ID.
+// Empty ID means a stream encoded before strategies were recorded.
+func lookupIndexStrategy(id string) (IndexStrategy, error) {
+	if id == "" {
+		return legacyDoubleHashing{}, nil
+	}
+	strategies.RLock()
+	defer strategies.RUnlock()
+	s, ok := strategies.m[id]
+	if !ok {
+		return nil, ErrUnknownIndexStrategy
+	}
+	return s, nil
+}
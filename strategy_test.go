@@ -0,0 +1,159 @@
+package blooms
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEnhancedDoubleHashing_Index(t *testing.T) {
+	Convey("Given filter size over 32bit which is not power of two", t, func() {
+		size := 6000000007
+		k := 7
+		buckets := 100
+		samples := 50000
+
+		Convey("When sampling indices of many elements", func() {
+			counts := make([]float64, buckets)
+			var upper, outside int
+			for n := 0; n < samples; n++ {
+				h1, h2 := sum128(DefaultHasher, []byte{byte(n), byte(n >> 8), byte(n >> 16)})
+				for i := 0; i < k; i++ {
+					idx := EnhancedDoubleHashing.Index(h1, h2, i, size)
+					if idx < 0 || idx >= size {
+						outside++
+						continue
+					}
+					if idx >= 1<<32 {
+						upper++
+					}
+					counts[int(float64(idx)/float64(size)*float64(buckets))]++
+				}
+			}
+
+			Convey("Then indices should be distributed uniformly over whole size", func() {
+				So(outside, ShouldEqual, 0)
+				expected := float64(samples*k) / float64(buckets)
+				var chi2 float64
+				for _, c := range counts {
+					chi2 += (c - expected) * (c - expected) / expected
+				}
+				// Critical value of chi-square for 99 degrees of freedom at p = 0.001
+				So(chi2, ShouldBeLessThan, 148.23)
+
+				upperRatio := float64(upper) / float64(samples*k)
+				So(upperRatio, ShouldAlmostEqual, float64(size-1<<32)/float64(size), 0.01)
+
+			})
+		})
+	})
+}
+
+func TestDoubleHashing_Index(t *testing.T) {
+	Convey("Given hash whose second half is 0", t, func() {
+		var h1, h2 uint64 = 12345, 0
+		size := 128
+
+		Convey("When computing indices", func() {
+			seen := map[int]bool{}
+			for i := 0; i < 5; i++ {
+				seen[DoubleHashing.Index(h1, h2, i, size)] = true
+			}
+
+			Convey("Then every index should differ", func() {
+				So(len(seen), ShouldEqual, 5)
+
+			})
+		})
+	})
+}
+
+func TestIndexStrategy_FalsePositive(t *testing.T) {
+	Convey("Given filters for every index strategy", t, func() {
+		n := 2000
+		p := 0.01
+		m := GetBestFilterSize(n, p)
+		k := 7
+		queries := 200000
+
+		theory := math.Pow(1-math.Exp(-float64(k*n)/float64(m)), float64(k))
+		strategies := []IndexStrategy{DoubleHashing, EnhancedDoubleHashing, TripleHashing, SeededHashing}
+
+		Convey("When querying elements never added", func() {
+			measured := make([]float64, len(strategies))
+			for si, s := range strategies {
+				b := New(m, k, WithIndexStrategy(s))
+				for i := 0; i < n; i++ {
+					b.Add([]byte{'a', byte(i), byte(i >> 8), byte(i >> 16)})
+				}
+				var fp int
+				for i := 0; i < queries; i++ {
+					if b.Has([]byte{'b', byte(i), byte(i >> 8), byte(i >> 16)}) {
+						fp++
+					}
+				}
+				measured[si] = float64(fp) / float64(queries)
+			}
+
+			Convey("Then false positive rate should be close to theory", func() {
+				for si := range strategies {
+					So(measured[si], ShouldAlmostEqual, theory, theory*0.25)
+				}
+
+			})
+		})
+	})
+}
+
+func TestWithIndexStrategy(t *testing.T) {
+	Convey("Given filters with triple hashing", t, func() {
+		b := New(128, 3, WithIndexStrategy(TripleHashing))
+		sf := NewScalableFilter(128, 2, 0.01, 0.8, WithIndexStrategy(TripleHashing))
+		b.Add([]byte("test"))
+		sf.Add([]byte("test"))
+
+		Convey("When decoding gobs stream", func() {
+			buf, _ := b.GobEncode()
+			res := &BloomFilter{}
+			err := res.GobDecode(buf)
+
+			sbuf, _ := sf.GobEncode()
+			sres := &ScalableFilter{}
+			serr := sres.GobDecode(sbuf)
+
+			Convey("Then strategy should be restored", func() {
+				So(err, ShouldBeNil)
+				So(res.strategyID(), ShouldEqual, TripleHashing.ID())
+				So(res.Has([]byte("test")), ShouldBeTrue)
+				So(serr, ShouldBeNil)
+				So(sres.strategy.ID(), ShouldEqual, TripleHashing.ID())
+				So(sres.filters[0].strategyID(), ShouldEqual, TripleHashing.ID())
+				So(sres.Has([]byte("test")), ShouldBeTrue)
+
+			})
+		})
+
+		Convey("When decoding gobs stream with unregistered strategy", func() {
+			bg := b.toGobs()
+			bg.Strategy = "unregistered"
+			buf, _ := gobEncode(bg)
+			err := (&BloomFilter{}).GobDecode(buf)
+
+			Convey("Then error should be returned", func() {
+				So(err, ShouldEqual, ErrUnknownIndexStrategy)
+
+			})
+		})
+
+		Convey("When merging filter with another strategy", func() {
+			err := b.Merge(New(128, 3))
+
+			Convey("Then error should be returned", func() {
+				So(errors.Is(err, ErrIncompatibleFilter), ShouldBeTrue)
+
+			})
+		})
+	})
+}
@@ -0,0 +1,205 @@
+package blooms
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidFilter is returned when decoded filter breaks its invariants
+var ErrInvalidFilter = errors.New("blooms: invalid filter")
+
+// maxSlots is the max number of slots of a filter which can be decoded.
+// Indices within it never overflow int.
+const maxSlots = int(^uint(0) >> 2)
+
+// maxGrowthRate is the max growth rate of scalable filter which can be decoded,
+// so that the next filter is not much larger than the last one already allocated
+const maxGrowthRate = 16
+
+// InvalidFilterError describes which invariant decoded filter breaks.
+// It matches ErrInvalidFilter with errors.Is.
+type InvalidFilterError struct {
+	// Name of invalid field
+	Field string
+	// Broken invariant
+	Reason string
+}
+
+func (e *InvalidFilterError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrInvalidFilter, e.Field, e.Reason)
+}
+
+// Is reports whether target is ErrInvalidFilter
+func (e *InvalidFilterError) Is(target error) bool {
+	return target == ErrInvalidFilter
+}
+
+func invalid(field, format string, args ...interface{}) error {
+	return &InvalidFilterError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// nested prefixes field of err with i-th element of field
+func nested(field string, i int, err error) error {
+	if e, ok := err.(*InvalidFilterError); ok {
+		return &InvalidFilterError{Field: fmt.Sprintf("%s[%d].%s", field, i, e.Field), Reason: e.Reason}
+	}
+	return err
+}
+
+// validProbability checks if p is a number within [min, max]
+func validProbability(field string, p, min, max float64) error {
+	if math.IsNaN(p) || p < min || p > max {
+		return invalid(field, "must be within [%v, %v], but %v", min, max, p)
+	}
+	return nil
+}
+
+// validateParams checks parameters of decoded base filter
+func (b *baseFilter) validateParams() error {
+	switch {
+	case b.m <= 0 || b.m > maxSlots:
+		return invalid("M", "must be within [1, %d], but %d", maxSlots, b.m)
+	case b.k <= 0 || b.k > b.m:
+		return invalid("K", "must be within [1, M=%d], but %d", b.m, b.k)
+	case b.s < 0 || b.s > b.m/b.k:
+		return invalid("S", "must be within [0, M/K=%d], but %d", b.m/b.k, b.s)
+	case b.n < 0:
+		return invalid("N", "must not be negative, but %d", b.n)
+	}
+	return nil
+}
+
+// validate checks invariants of decoded base filter
+func (b *baseFilter) validate() error {
+	err := b.validateParams()
+	if err != nil {
+		return err
+	}
+	switch bits := b.bits.(type) {
+	case bitSet:
+		if len(bits) != wordNumber(b.m) {
+			return invalid("Words", "must hold %d bits, but %d words", b.m, len(bits))
+		}
+	case counterSet:
+		if len(bits) != b.m {
+			return invalid("Bits", "must hold %d counters, but %d", b.m, len(bits))
+		}
+	default:
+		return invalid("Bits", "are missing")
+	}
+	return nil
+}
+
+// validate checks invariants of decoded partitioned filter
+func (p *PartitionedFilter) validate() error {
+	err := p.baseFilter.validate()
+	if err != nil {
+		return err
+	}
+	if p.maxN < 0 {
+		return invalid("MaxN", "must not be negative, but %d", p.maxN)
+	}
+	return validProbability("P", p.p, 0, 1)
+}
+
+// validate checks invariants of decoded scalable filter and consistency of its filters,
+// and restores level of every filter
+func (sf *ScalableFilter) validate() error {
+	switch {
+	case sf.m <= 0 || sf.m > maxSlots:
+		return invalid("M", "must be within [1, %d], but %d", maxSlots, sf.m)
+	case sf.k < 0:
+		// Origin is 0 for expected incidence of false positive over 0.5,
+		// and every filter has a hash function more
+		return invalid("K", "must not be negative, but %d", sf.k)
+	case sf.n < 0:
+		return invalid("N", "must not be negative, but %d", sf.n)
+	case sf.maxN < 0:
+		return invalid("MaxN", "must not be negative, but %d", sf.maxN)
+	case sf.growthRate <= 0 || sf.growthRate > maxGrowthRate:
+		return invalid("GrowthRate", "must be within [1, %d], but %d", maxGrowthRate, sf.growthRate)
+	case len(sf.filters) == 0:
+		return invalid("Filters", "must not be empty")
+	}
+	err := validProbability("P", sf.p, math.SmallestNonzeroFloat64, 1)
+	if err != nil {
+		return err
+	}
+	err = validProbability("FpReduction", sf.fpReduction, math.SmallestNonzeroFloat64, 1)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	level, size := 0, sf.m
+	for i, pf := range sf.filters {
+		err := pf.validate()
+		if err != nil {
+			return nested("Filters", i, err)
+		}
+		if i > 0 && !sf.isSameLevel(pf, sf.filters[i-1], level) {
+			if size > maxSlots/sf.growthRate {
+				return invalid("Filters", "%d is of a level whose size is over %d", i, maxSlots)
+			}
+			level++
+			size *= sf.growthRate
+		}
+		pf.level = level
+		switch {
+		case pf.seed != 0 && pf.seed != stageSeed(sf.seed, level):
+			return invalid("Filters", "%d has seed of no level from %d, in order of levels", i, level)
+		case pf.m != size:
+			return invalid("Filters", "%d has size %d instead of M*GrowthRate^%d=%d", i, pf.m, level, size)
+		case pf.hasherID() != sf.hasher.ID():
+			return invalid("Filters", "%d has hasher %s instead of %s", i, pf.hasherID(), sf.hasher.ID())
+		case keyCheck(pf.hasher) != keyCheck(sf.hasher):
+			return invalid("Filters", "%d has another key", i)
+		case pf.strategyID() != sf.strategy.ID():
+			return invalid("Filters", "%d has strategy %s instead of %s", i, pf.strategyID(), sf.strategy.ID())
+		}
+		n += int64(pf.n)
+	}
+	if n != sf.n {
+		return invalid("N", "must be sum of filters %d, but %d", n, sf.n)
+	}
+	// Size of the next filter must be representable
+	if sf.filters.Last().m > maxSlots/sf.growthRate {
+		return invalid("Filters", "are too many to grow, next size is over %d", maxSlots)
+	}
+	return nil
+}
+
+// isSameLevel checks if pf is of the level of the previous filter,
+// whose seed is derived from the level.
+// Filter without seed is encoded before seeds were recorded,
+// and it is of the same level only if it is built alike the previous filter.
+func (sf *ScalableFilter) isSameLevel(pf, prev *PartitionedFilter, level int) bool {
+	if pf.seed == 0 {
+		return pf.m == prev.m && pf.k == prev.k && pf.p == prev.p
+	}
+	return pf.seed == stageSeed(sf.seed, level)
+}
+
+// validate checks invariants of decoded sharded filter
+func (sf *shardedBase) validate() error {
+	if len(sf.shards) == 0 {
+		return invalid("Shards", "must not be empty")
+	}
+	for i, s := range sf.shards {
+		err := s.validate()
+		if err != nil {
+			return nested("Shards", i, err)
+		}
+		_, counting := s.bits.(counterSet)
+		switch {
+		case counting != sf.counting:
+			return invalid("Shards", "%d has counters %v, but counting is %v", i, counting, sf.counting)
+		case s.hasherID() != sf.shards[0].hasherID():
+			return invalid("Shards", "%d has hasher %s instead of %s", i, s.hasherID(), sf.shards[0].hasherID())
+		case keyCheck(s.hasher) != keyCheck(sf.shards[0].hasher):
+			return invalid("Shards", "%d has another key", i)
+		}
+	}
+	return nil
+}
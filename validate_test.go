@@ -0,0 +1,181 @@
+package blooms
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGobDecode_Invalid(t *testing.T) {
+	Convey("Given gobs streams breaking invariants", t, func() {
+		valid := func() *baseGobs {
+			return New(128, 3).toGobs()
+		}
+		cases := []struct {
+			name  string
+			gobs  func() *baseGobs
+			field string
+		}{
+			{"no hash function", func() *baseGobs { g := valid(); g.K = 0; return g }, "K"},
+			{"empty bit map", func() *baseGobs { g := valid(); g.Words = nil; return g }, "M"},
+			{"short bit map", func() *baseGobs { g := valid(); g.Words = g.Words[:1]; return g }, "Words"},
+			{"partitions over bit map", func() *baseGobs { g := valid(); g.S = 43; return g }, "S"},
+			{"negative number of elements", func() *baseGobs { g := valid(); g.N = -1; return g }, "N"},
+		}
+
+		Convey("When decoding them", func() {
+			for _, c := range cases {
+				buf, err := gobEncode(c.gobs())
+				So(err, ShouldBeNil)
+				b := New(128, 3)
+				b.Add([]byte("kept"))
+				err = b.GobDecode(buf)
+
+				Convey(fmt.Sprintf("Then typed error should be returned for %s", c.name), func() {
+					So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+					So(err.(*InvalidFilterError).Field, ShouldEqual, c.field)
+					So(b.Has([]byte("kept")), ShouldBeTrue)
+
+				})
+			}
+		})
+	})
+
+	Convey("Given gobs streams of scalable filter with inconsistent filters", t, func() {
+		valid := func() *scalableGobs {
+			sf := NewScalableFilter(64, 2, 0.1, 0.5)
+			for i := 0; i < 20; i++ {
+				sf.Add([]byte{byte(i)})
+			}
+			return sf.toGobs()
+		}
+		cases := []struct {
+			name  string
+			gobs  func() *scalableGobs
+			field string
+		}{
+			{"no filter", func() *scalableGobs { g := valid(); g.Filters = nil; g.Seeds = nil; return g }, "Filters"},
+			{"no growth", func() *scalableGobs { g := valid(); g.GrowthRate = 0; return g }, "GrowthRate"},
+			{"no reduction", func() *scalableGobs { g := valid(); g.FpReduction = 0; return g }, "FpReduction"},
+			{"growth beyond bound", func() *scalableGobs { g := valid(); g.GrowthRate = 1 << 40; return g }, "GrowthRate"},
+			{"filter size out of growth", func() *scalableGobs { g := valid(); g.M = 48; return g }, "Filters"},
+			{"filters out of order", func() *scalableGobs {
+				g := valid()
+				g.Filters[0], g.Filters[1] = g.Filters[1], g.Filters[0]
+				g.Seeds[0], g.Seeds[1] = g.Seeds[1], g.Seeds[0]
+				return g
+			}, "Filters"},
+			{"another hasher", func() *scalableGobs { g := valid(); g.Filters[1].Base.Hasher = "fnv1a-64"; return g }, "Filters"},
+			{"invalid filter", func() *scalableGobs { g := valid(); g.Filters[1].Base.K = 0; return g }, "K"},
+			{"count not sum of filters", func() *scalableGobs { g := valid(); g.N++; return g }, "N"},
+			{"seeds not for every filter", func() *scalableGobs { g := valid(); g.Seeds = g.Seeds[:1]; return g }, "Seeds"},
+		}
+
+		Convey("When decoding them", func() {
+			for _, c := range cases {
+				buf, err := gobEncode(c.gobs())
+				So(err, ShouldBeNil)
+				err = (&ScalableFilter{}).GobDecode(buf)
+
+				Convey(fmt.Sprintf("Then typed error should be returned for %s", c.name), func() {
+					So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+					So(err.(*InvalidFilterError).Field, ShouldEqual, c.field)
+
+				})
+			}
+		})
+	})
+
+	Convey("Given gobs stream of sharded filter without shards", t, func() {
+		buf, err := gobEncode(&shardedGobs{})
+		So(err, ShouldBeNil)
+
+		Convey("When decoding it", func() {
+			err := (&ShardedFilter{}).GobDecode(buf)
+
+			Convey("Then typed error should be returned", func() {
+				So(err, ShouldResemble, &InvalidFilterError{Field: "Shards", Reason: "must not be empty"})
+
+			})
+		})
+	})
+}
+
+func TestUnmarshalBinary_InvalidFilter(t *testing.T) {
+	Convey("Given binary data of bloom filter claiming a huge size", t, func() {
+		data, err := New(128, 3).MarshalBinary()
+		So(err, ShouldBeNil)
+		// m follows 6 bytes of header
+		binary.LittleEndian.PutUint64(data[6:], 1<<50)
+
+		Convey("When reading it", func() {
+			_, err := (&BloomFilter{}).ReadFrom(bytes.NewReader(data))
+
+			Convey("Then it should fail without allocating the size", func() {
+				So(err, ShouldEqual, io.ErrUnexpectedEOF)
+
+			})
+		})
+	})
+
+	Convey("Given binary data of bloom filter without hash functions", t, func() {
+		data, err := New(128, 3).MarshalBinary()
+		So(err, ShouldBeNil)
+		binary.LittleEndian.PutUint32(data[14:], 0)
+
+		Convey("When decoding it", func() {
+			err := (&BloomFilter{}).UnmarshalBinary(data)
+
+			Convey("Then typed error should be returned", func() {
+				So(errors.Is(err, ErrInvalidFilter), ShouldBeTrue)
+				So(err.(*InvalidFilterError).Field, ShouldEqual, "K")
+
+			})
+		})
+	})
+}
+
+func TestConstructors_RoundTrip(t *testing.T) {
+	Convey("Given filters built with parameters out of range", t, func() {
+		filters := []struct {
+			name   string
+			filter Filter
+			empty  Filter
+		}{
+			{"no slot", New(0, 3), &BloomFilter{}},
+			{"no hash function", New(64, 0), &BloomFilter{}},
+			{"more hash functions than slots", NewCountingFilter(4, 5), &CountingFilter{}},
+			{"more partitions than slots", NewPartitionedFilter(4, 5), &PartitionedFilter{}},
+			{"atomic filter of no slot", NewAtomicFilter(-1, 3), &AtomicFilter{}},
+			{"no origin hash function", NewScalableFilter(64, 2, 0.6, 0.5), &ScalableFilter{}},
+			{"no growth", NewScalableFilter(64, 0, 0.01, 0.5), &ScalableFilter{}},
+			{"growth beyond bound", NewScalableFilter(64, 17, 0.01, 0.5), &ScalableFilter{}},
+			{"probabilities out of range", NewScalableFilter(0, 2, 0, 2), &ScalableFilter{}},
+		}
+
+		Convey("When encoding and decoding them", func() {
+			for _, f := range filters {
+				f.filter.Add([]byte("test"))
+				data, merr := f.filter.(encoding.BinaryMarshaler).MarshalBinary()
+				uerr := f.empty.(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+				buf, _ := f.filter.(gob.GobEncoder).GobEncode()
+				gerr := f.empty.(gob.GobDecoder).GobDecode(buf)
+
+				Convey(fmt.Sprintf("Then filter should be decoded from its own encoding for %s", f.name), func() {
+					So(merr, ShouldBeNil)
+					So(uerr, ShouldBeNil)
+					So(gerr, ShouldBeNil)
+					So(f.empty.Has([]byte("test")), ShouldBeTrue)
+
+				})
+			}
+		})
+	})
+}